@@ -0,0 +1,343 @@
+package fpoc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/jinzhu/copier"
+	"github.com/sardinasystems/fleeting-plugin-openstack/internal/openstackclient"
+)
+
+const (
+	backendMaxFailures = 3
+	backendCooldown    = 5 * time.Minute
+
+	// backendSeparator separates backend name and server ID in the instance ID
+	backendSeparator = "/"
+)
+
+// BackendConfig additional cloud or region used for the instances of the group
+type BackendConfig struct {
+	Name         string        `json:"name"`          // unique name, prefix of the instance IDs
+	Cloud        string        `json:"cloud"`         // optional: cloud from clouds.yaml, default cloud of the group
+	CloudsConfig string        `json:"clouds_config"` // optional: path to clouds.yaml, default clouds_config of the group
+	Region       string        `json:"region"`        // optional: region of the cloud
+	Priority     int           `json:"priority"`      // optional: backends with lower priority are used first, default 0 (as the primary cloud)
+	Weight       int           `json:"weight"`        // optional: share of instances among backends of the same priority, default 1
+	ServerSpec   ExtCreateOpts `json:"server_spec"`   // optional: non-empty fields override the group server_spec
+
+	AvailabilityZones ZoneConfig `json:"availability_zones"` // optional: spread instances of the backend across its availability zones
+}
+
+// backend cloud or region owning a part of the instances
+type backend struct {
+	name     string // empty for the primary cloud, its instance IDs have no prefix
+	priority int
+	weight   int
+	client   openstackclient.Client
+	spec     *ExtCreateOpts // overrides of the server spec, nil for the primary cloud
+	groups   *serverGroupPool
+	zones    *zoneBalancer
+	dynamic  bool // spec with the overrides has templates or index placeholder in the name
+
+	mu        sync.Mutex
+	failures  int              // consecutive failures
+	downUntil time.Time        // unhealthy until
+	current   int              // smooth weighted round-robin state, guarded by InstanceGroup.backendMu
+	known     []servers.Server // servers of the group as of last successful listing
+}
+
+func (b *backend) healthy(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return !now.Before(b.downUntil)
+}
+
+// fail records failed request, returns true if the backend became unhealthy
+func (b *backend) fail() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.failures < backendMaxFailures {
+		return false
+	}
+
+	b.failures = 0
+	b.downUntil = time.Now().Add(backendCooldown)
+	return true
+}
+
+func (b *backend) succeed() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+}
+
+// instanceID returns ID of the server unique within the group
+func (b *backend) instanceID(serverID string) string {
+	if b.name == "" {
+		return serverID
+	}
+
+	return b.name + backendSeparator + serverID
+}
+
+// applySpec overrides fields of the spec by the backend ones
+func (b *backend) applySpec(spec *ExtCreateOpts) error {
+	if b.spec == nil {
+		return nil
+	}
+
+	return copier.CopyWithOption(spec, b.spec, copier.Option{IgnoreEmpty: true, DeepCopy: true})
+}
+
+// backendSpec returns copy of the current server spec with the backend overrides and its templates
+func (g *InstanceGroup) backendSpec(ctx context.Context, b *backend) (*ExtCreateOpts, *specTemplates, error) {
+	spec, templates, err := g.currentSpec(ctx)
+	if err != nil || b.spec == nil {
+		return spec, templates, err
+	}
+
+	err = b.applySpec(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// overrides may have own templates, e.g. description or metadata
+	templates, err = compileSpecTemplates(spec, g.UserDataTemplate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("backend %s: %w", b.name, err)
+	}
+
+	return spec, templates, nil
+}
+
+// checkBackendTemplates checks templates of the server spec with the overrides of each backend
+func (g *InstanceGroup) checkBackendTemplates() error {
+	for _, b := range g.getBackends() {
+		if b.spec == nil {
+			continue
+		}
+
+		spec := new(ExtCreateOpts)
+		err := copier.CopyWithOption(spec, &g.ServerSpec, copier.Option{DeepCopy: true})
+		if err == nil {
+			err = b.applySpec(spec)
+		}
+		if err != nil {
+			return err
+		}
+
+		st, _, err := g.checkTemplates(spec)
+		if err != nil {
+			return fmt.Errorf("backend %s: %w", b.name, err)
+		}
+
+		b.dynamic = strings.Contains(spec.Name, "%") || !st.static()
+	}
+
+	return nil
+}
+
+// initBackends creates clients of the additional backends, the primary cloud is the first backend
+func (g *InstanceGroup) initBackends() error {
+	g.backends = []*backend{{client: g.client, weight: 1}}
+
+	names := make(map[string]struct{}, len(g.Backends))
+	for idx := range g.Backends {
+		cfg := &g.Backends[idx]
+
+		if cfg.Name == "" || strings.Contains(cfg.Name, backendSeparator) {
+			return fmt.Errorf("backends[%d]: name must be set and must not contain %q", idx, backendSeparator)
+		}
+		if _, ok := names[cfg.Name]; ok {
+			return fmt.Errorf("backends[%d]: duplicate name %s", idx, cfg.Name)
+		}
+		names[cfg.Name] = struct{}{}
+
+		if cfg.Weight < 0 {
+			return fmt.Errorf("backends[%d]: weight must not be negative", idx)
+		} else if cfg.Weight == 0 {
+			cfg.Weight = 1
+		}
+
+		cloud := cfg.Cloud
+		if cloud == "" {
+			cloud = g.Cloud
+		}
+		cloudsConfig := cfg.CloudsConfig
+		if cloudsConfig == "" {
+			cloudsConfig = g.CloudsConfig
+		}
+
+		// connect on the first request, so a cloud down on startup does not fail the group
+		client := openstackclient.NewLazy(&openstackclient.EnvCloudConfig{
+			CloudConfig: openstackclient.CloudConfig{
+				ClientConfigFile:  cloudsConfig,
+				Cloud:             cloud,
+				RegionName:        cfg.Region,
+				ComputeApiVersion: g.NovaMicroversion,
+			},
+		}, nil)
+
+		g.backends = append(g.backends, &backend{
+			name:     cfg.Name,
+			priority: cfg.Priority,
+			weight:   cfg.Weight,
+			client:   client,
+			spec:     &cfg.ServerSpec,
+		})
+
+		g.log.Info("Backend added", "backend", cfg.Name, "cloud", cloud, "region", cfg.Region, "priority", cfg.Priority, "weight", cfg.Weight)
+	}
+
+	return nil
+}
+
+// getBackends returns backends of the group, the primary cloud only if Init did not set them up
+func (g *InstanceGroup) getBackends() []*backend {
+	if len(g.backends) == 0 {
+		return []*backend{{client: g.client, weight: 1}}
+	}
+
+	return g.backends
+}
+
+// route returns backend owning the instance and the server ID in it
+func (g *InstanceGroup) route(instanceID string) (*backend, string, error) {
+	backends := g.getBackends()
+
+	name, serverID, ok := strings.Cut(instanceID, backendSeparator)
+	if !ok {
+		return backends[0], instanceID, nil
+	}
+
+	for _, b := range backends {
+		if b.name != "" && b.name == name {
+			return b, serverID, nil
+		}
+	}
+
+	return nil, "", fmt.Errorf("unknown backend of instance %s", instanceID)
+}
+
+// plannedInstance instance to create by Increase, flavorRef overrides the flavor of the spec if set
+type plannedInstance struct {
+	backend   *backend
+	flavorRef string
+}
+
+// pickBackend selects healthy backend with the lowest priority, backends of the same priority share instances by weight
+func (g *InstanceGroup) pickBackend() *backend {
+	backends := g.getBackends()
+	if len(backends) == 1 {
+		return backends[0]
+	}
+
+	now := time.Now()
+	candidates := make([]*backend, 0, len(backends))
+	for _, b := range backends {
+		if b.healthy(now) {
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		g.log.Warn("All backends are unhealthy, using all of them")
+		candidates = backends
+	}
+
+	best := candidates[0].priority
+	for _, b := range candidates {
+		best = min(best, b.priority)
+	}
+
+	g.backendMu.Lock()
+	defer g.backendMu.Unlock()
+
+	var picked *backend
+	total := 0
+	for _, b := range candidates {
+		if b.priority != best {
+			continue
+		}
+
+		b.current += b.weight
+		total += b.weight
+		if picked == nil || b.current > picked.current {
+			picked = b
+		}
+	}
+	picked.current -= total
+
+	return picked
+}
+
+// backendFailed records failed request to the backend
+func (g *InstanceGroup) backendFailed(b *backend, err error) {
+	if len(g.getBackends()) > 1 && b.fail() {
+		g.log.Warn("Backend marked unhealthy", "backend", b.name, "err", err, "cooldown", backendCooldown)
+	}
+}
+
+// getInstances returns servers of the group in all backends, IDs are replaced by the instance IDs.
+// Backends failed to list return the servers known from their last listing, IDs of them are returned as stale.
+// Error is returned without servers if no backend could be listed.
+func (g *InstanceGroup) getInstances(ctx context.Context) (instances []servers.Server, stale map[string]struct{}, err error) {
+	var errs []error
+
+	backends := g.getBackends()
+	for _, b := range backends {
+		allServers, err := b.client.ListServers(ctx)
+		if err != nil {
+			if b.name != "" {
+				err = fmt.Errorf("backend %s: %w", b.name, err)
+			}
+			g.backendFailed(b, err)
+			errs = append(errs, err)
+
+			b.mu.Lock()
+			if stale == nil {
+				stale = make(map[string]struct{})
+			}
+			for _, srv := range b.known {
+				stale[srv.ID] = struct{}{}
+				instances = append(instances, srv)
+			}
+			b.mu.Unlock()
+
+			continue
+		}
+		b.succeed()
+
+		known := make([]servers.Server, 0, len(allServers))
+		for _, srv := range allServers {
+			cluster, ok := srv.Metadata[MetadataKey]
+			if !ok || cluster != g.Name {
+				continue
+			}
+
+			srv.ID = b.instanceID(srv.ID)
+			known = append(known, srv)
+		}
+
+		b.mu.Lock()
+		b.known = known
+		b.mu.Unlock()
+
+		instances = append(instances, known...)
+	}
+
+	if len(errs) == len(backends) {
+		return nil, nil, errors.Join(errs...)
+	}
+
+	return instances, stale, errors.Join(errs...)
+}
@@ -0,0 +1,180 @@
+package fpoc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/limits"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/fleeting/fleeting/provider"
+)
+
+func TestRoute(t *testing.T) {
+	primary := &backend{weight: 1}
+	west := &backend{name: "west", weight: 1}
+	g := &InstanceGroup{backends: []*backend{primary, west}}
+
+	b, serverID, err := g.route("a1b2")
+	require.NoError(t, err)
+	assert.Same(t, primary, b)
+	assert.Equal(t, "a1b2", serverID)
+
+	b, serverID, err = g.route(west.instanceID("c3d4"))
+	require.NoError(t, err)
+	assert.Same(t, west, b)
+	assert.Equal(t, "c3d4", serverID)
+
+	_, _, err = g.route("east/e5f6")
+	assert.EqualError(t, err, "unknown backend of instance east/e5f6")
+}
+
+func TestPickBackend(t *testing.T) {
+	primary := &backend{weight: 1}
+	west := &backend{name: "west", weight: 2}
+	spare := &backend{name: "spare", priority: 1, weight: 1}
+	g := &InstanceGroup{
+		log:      hclog.NewNullLogger(),
+		backends: []*backend{primary, west, spare},
+	}
+
+	pick := func(n int) []string {
+		var ret []string
+		for range n {
+			ret = append(ret, g.pickBackend().name)
+		}
+		return ret
+	}
+
+	assert.Equal(t, []string{"west", "", "west", "west", "", "west"}, pick(6))
+
+	for range backendMaxFailures {
+		g.backendFailed(primary, errors.New("connection refused"))
+		g.backendFailed(west, errors.New("connection refused"))
+	}
+	assert.Equal(t, []string{"spare", "spare"}, pick(2))
+
+	g.backendFailed(spare, errors.New("connection refused"))
+	assert.Equal(t, []string{"spare"}, pick(1), "single failure keeps backend healthy")
+}
+
+func TestBackendApplySpec(t *testing.T) {
+	spec := &ExtCreateOpts{
+		ImageName: "flatcar",
+		Networks:  []servers.Network{{UUID: "net-primary"}},
+	}
+	spec.Name = "runner-%d"
+	spec.FlavorRef = "m1.large"
+	spec.Metadata = map[string]string{"role": "ci"}
+
+	override := &ExtCreateOpts{Networks: []servers.Network{{UUID: "net-west"}}}
+	override.FlavorRef = "west.large"
+	override.Metadata = map[string]string{"region": "west"}
+
+	b := &backend{name: "west", spec: override}
+	require.NoError(t, b.applySpec(spec))
+
+	assert.Equal(t, "runner-%d", spec.Name)
+	assert.Equal(t, "flatcar", spec.ImageName)
+	assert.Equal(t, "west.large", spec.FlavorRef)
+	assert.Equal(t, []servers.Network{{UUID: "net-west"}}, spec.Networks)
+	assert.Equal(t, map[string]string{"role": "ci", "region": "west"}, spec.Metadata)
+}
+
+func TestMultiBackend(t *testing.T) {
+	metadata := map[string]string{MetadataKey: "ci"}
+	unlimited := limits.Absolute{MaxTotalInstances: -1, MaxTotalCores: -1, MaxTotalRAMSize: -1}
+	primaryClient := &fakeFallbackClient{servers: []servers.Server{{ID: "p1", Status: "BUILD", Metadata: metadata}}}
+	primaryClient.limits = unlimited
+	westClient := &fakeFallbackClient{servers: []servers.Server{{ID: "w1", Status: "BUILD", Metadata: metadata}}}
+	westClient.limits = unlimited
+
+	g := &InstanceGroup{
+		Name:     "ci",
+		log:      hclog.NewNullLogger(),
+		client:   primaryClient,
+		Backends: []BackendConfig{{Name: "west"}},
+		backends: []*backend{
+			{client: primaryClient, weight: 1},
+			{name: "west", client: westClient, weight: 1, spec: &ExtCreateOpts{
+				ImageName:   "flatcar-west",
+				Description: "west runner",
+				CreateOpts:  servers.CreateOpts{Metadata: map[string]string{"role": "west"}},
+			}},
+		},
+	}
+	g.bgCtx, g.bgCancel = context.WithCancel(context.Background())
+	defer g.bgCancel()
+
+	g.ServerSpec.Name = "runner-%d"
+	g.ServerSpec.ImageName = "flatcar"
+	g.ServerSpec.Description = "{{ .Cluster }} runner"
+	g.ServerSpec.Metadata = map[string]string{"role": "{{ .Cluster }}", "team": "infra"}
+
+	var err error
+	g.templates, _, err = g.checkTemplates(&g.ServerSpec)
+	require.NoError(t, err)
+	require.NoError(t, g.checkBackendTemplates())
+
+	states := make(map[string]provider.State)
+	err = g.Update(context.Background(), func(id string, state provider.State) {
+		states[id] = state
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]provider.State{"p1": provider.StateCreating, "west/w1": provider.StateCreating}, states)
+
+	// failed backend keeps its instances with the last reported state
+	g.lastStates["west/w1"] = provider.StateRunning
+	westClient.listErr = errors.New("service unavailable")
+	states = make(map[string]provider.State)
+	err = g.Update(context.Background(), func(id string, state provider.State) {
+		states[id] = state
+	})
+	assert.ErrorContains(t, err, "backend west: service unavailable")
+	assert.Equal(t, map[string]provider.State{"p1": provider.StateCreating, "west/w1": provider.StateRunning}, states)
+	assert.EqualValues(t, 2, g.instanceCount.Load())
+	westClient.listErr = nil
+
+	succeeded, err := g.Increase(context.Background(), 4)
+	require.NoError(t, err)
+	assert.Equal(t, 4, succeeded)
+	require.Len(t, primaryClient.created, 2)
+	require.Len(t, westClient.created, 2)
+	assert.Equal(t, "image-flatcar", primaryClient.created[0].ImageRef)
+	assert.Equal(t, "image-flatcar-west", westClient.created[0].ImageRef)
+
+	// backend overrides are not replaced by the templates of the primary spec
+	assert.Equal(t, "ci runner", primaryClient.created[0].Description)
+	assert.Equal(t, "ci", primaryClient.created[0].Metadata["role"])
+	assert.Equal(t, "west runner", westClient.created[0].Description)
+	assert.Equal(t, "west", westClient.created[0].Metadata["role"])
+	assert.Equal(t, "infra", westClient.created[0].Metadata["team"])
+
+	// quota is checked for the share of each backend
+	westClient.limits = limits.Absolute{MaxTotalInstances: 4, TotalInstancesUsed: 3, MaxTotalCores: -1, MaxTotalRAMSize: -1}
+	succeeded, err = g.Increase(context.Background(), 4)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	assert.ErrorContains(t, err, "backend west: quota exceeded: 1 of 2 instances not created")
+	assert.Equal(t, 3, succeeded)
+	assert.Len(t, primaryClient.created, 4)
+	assert.Len(t, westClient.created, 3)
+
+	// image of a backend can't be resolved, instances of other backends are created
+	westClient.limits = unlimited
+	westClient.imageErr = errors.New("image service unavailable")
+	succeeded, err = g.Increase(context.Background(), 4)
+	assert.ErrorContains(t, err, "backend west: image service unavailable")
+	assert.Equal(t, 2, succeeded)
+	assert.Len(t, primaryClient.created, 6)
+	assert.Len(t, westClient.created, 3)
+	westClient.imageErr = nil
+
+	deleted, err := g.Decrease(context.Background(), []string{"p1", "west/w1"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"p1", "west/w1"}, deleted)
+	assert.Equal(t, []string{"p1"}, primaryClient.deleted)
+	assert.Equal(t, []string{"w1"}, westClient.deleted)
+}
@@ -0,0 +1,183 @@
+package fpoc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+)
+
+// servers of the reservation may be listed with a delay after the multi-create request
+var (
+	reservationListAttempts = 5
+	reservationListInterval = time.Second
+)
+
+func isStaticTemplate(tmpl *template.Template) bool {
+	if tmpl == nil || tmpl.Tree == nil || tmpl.Tree.Root == nil {
+		return true
+	}
+
+	for _, node := range tmpl.Tree.Root.Nodes {
+		if node.Type() != parse.NodeText {
+			return false
+		}
+	}
+
+	return true
+}
+
+// static checks that templates render the same text for all instances
+func (st *specTemplates) static() bool {
+	if st.name != nil || !isStaticTemplate(st.description) || !isStaticTemplate(st.userData) {
+		return false
+	}
+
+	for _, tmpl := range st.metadata {
+		if !isStaticTemplate(tmpl) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// batchBlockers returns the reasons why instances can't be created by one multi-create request
+func (g *InstanceGroup) batchBlockers() []string {
+	var ret []string
+
+	if g.Readiness.Token != nil {
+		ret = append(ret, "readiness.token is per instance")
+	}
+	if g.Readiness.Callback != nil {
+		ret = append(ret, "readiness.callback is per instance")
+	}
+	if g.ServerGroups != nil {
+		ret = append(ret, "server_groups assigns instances one by one")
+	}
+	if g.severalZones() {
+		ret = append(ret, "availability_zones has several zones")
+	}
+
+	g.specMu.Lock()
+	defer g.specMu.Unlock()
+
+	if strings.Contains(g.ServerSpec.Name, "%") {
+		ret = append(ret, "server_spec.name has index placeholder")
+	}
+	if g.templates != nil && !g.templates.static() {
+		ret = append(ret, "server_spec has templates")
+	}
+	for _, b := range g.backends {
+		if b.dynamic {
+			ret = append(ret, fmt.Sprintf("server_spec of backend %s has templates or index placeholder", b.name))
+		}
+	}
+
+	return ret
+}
+
+// createBatch creates up to count servers with one multi-create request, returns IDs of the created servers.
+// Nova names servers <name>-<n>, hostname is not set in the user data.
+func (g *InstanceGroup) createBatch(ctx context.Context, b *backend, imageRef string, count int) ([]string, error) {
+	spec, _, err := g.backendSpec(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep the counter in line with single creates
+	g.instanceCounter.Add(int32(count))
+
+	if imageRef != "" {
+		spec.ImageRef = imageRef
+	}
+
+	if b.zones != nil {
+		spec.AvailabilityZone = b.zones.pick()
+	}
+
+	if spec.Metadata == nil {
+		spec.Metadata = make(map[string]string)
+	}
+	spec.Metadata[MetadataKey] = g.Name
+	if len(spec.FlavorRefs) > 0 {
+		spec.Metadata[FlavorMetadataKey] = spec.FlavorRef
+	}
+	if b.zones != nil {
+		spec.Metadata[ZoneMetadataKey] = spec.AvailabilityZone
+	}
+
+	var hintOpts servers.SchedulerHintOptsBuilder
+	if spec.SchedulerHints != nil {
+		hintOpts = spec.SchedulerHints
+	}
+
+	// name is the same for all servers, so it's not used as hostname
+	name := spec.Name
+	spec.Name = ""
+	err = g.mergeUserData(spec, g.staticExtras())
+	spec.Name = name
+	if err == nil {
+		spec.UserData, err = g.fitUserData(spec.UserData)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	spec.Min = 1
+	spec.Max = count
+	spec.ReturnReservationID = true
+
+	reservationID, err := b.client.CreateServers(ctx, spec, hintOpts)
+	if err != nil {
+		g.backendFailed(b, err)
+		return nil, err
+	}
+	b.succeed()
+
+	lg := g.log.With("reservation_id", reservationID, "backend", b.name)
+	lg.Info("Batch creation request successful", "count", count)
+
+	srvs, err := b.listReservation(ctx, reservationID, count)
+
+	ids := make([]string, 0, len(srvs))
+	for _, srv := range srvs {
+		ids = append(ids, b.instanceID(srv.ID))
+	}
+
+	if err != nil {
+		return ids, fmt.Errorf("failed to list servers of reservation %s: %w", reservationID, err)
+	}
+	if len(ids) < count {
+		lg.Warn("Batch created less servers than requested", "count", count, "created", len(ids))
+		return ids, fmt.Errorf("batch created %d of %d instances, reservation %s", len(ids), count, reservationID)
+	}
+
+	return ids, nil
+}
+
+// listReservation lists servers of the reservation, retries a few times while Nova lists less than count of them
+func (b *backend) listReservation(ctx context.Context, reservationID string, count int) ([]servers.Server, error) {
+	var srvs []servers.Server
+	for attempt := 1; ; attempt++ {
+		ret, err := b.client.ListServersByReservation(ctx, reservationID)
+		if err != nil {
+			return srvs, err
+		}
+
+		srvs = ret
+		if len(srvs) >= count || attempt >= reservationListAttempts {
+			return srvs, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return srvs, nil
+		case <-time.After(reservationListInterval):
+		}
+	}
+}
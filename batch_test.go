@@ -0,0 +1,154 @@
+package fpoc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/limits"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBatchClient records multi-create requests, creates at most limit servers per request.
+// Servers of the reservation are listed after delayed listings.
+type fakeBatchClient struct {
+	fakeCreateClient
+
+	limit    int
+	delayed  int
+	listings int
+	batches  []*ExtCreateOpts
+}
+
+func (c *fakeBatchClient) CreateServers(ctx context.Context, spec servers.CreateOptsBuilder, hintOpts servers.SchedulerHintOptsBuilder) (string, error) {
+	c.batches = append(c.batches, spec.(*ExtCreateOpts))
+	return fmt.Sprintf("r-%d", len(c.batches)), nil
+}
+
+func (c *fakeBatchClient) ListServersByReservation(ctx context.Context, reservationId string) ([]servers.Server, error) {
+	spec := c.batches[len(c.batches)-1]
+
+	c.listings++
+	if c.listings <= c.delayed {
+		return nil, nil
+	}
+
+	count := min(spec.Max, c.limit)
+	ret := make([]servers.Server, 0, count)
+	for idx := range count {
+		ret = append(ret, servers.Server{ID: fmt.Sprintf("%s-srv-%d", reservationId, idx)})
+	}
+
+	return ret, nil
+}
+
+func TestBatchBlockers(t *testing.T) {
+	testCases := []struct {
+		name      string
+		setup     func(g *InstanceGroup)
+		srvName   string
+		metadata  map[string]string
+		expectLen int
+	}{
+		{"static", nil, "runner", map[string]string{"role": "ci"}, 0},
+		{"index placeholder", nil, "runner-%d", nil, 1},
+		{"name template", nil, "runner-{{ .Suffix }}", nil, 1},
+		{"metadata template", nil, "runner", map[string]string{"idx": "{{ .Index }}"}, 1},
+		{"token", func(g *InstanceGroup) { g.Readiness.Token = &TokenConfig{} }, "runner", nil, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := &InstanceGroup{log: hclog.NewNullLogger()}
+			g.ServerSpec.Name = tc.srvName
+			g.ServerSpec.Metadata = tc.metadata
+			if tc.setup != nil {
+				tc.setup(g)
+			}
+
+			var err error
+			g.templates, _, err = g.checkTemplates(&g.ServerSpec)
+			require.NoError(t, err)
+
+			assert.Len(t, g.batchBlockers(), tc.expectLen)
+		})
+	}
+}
+
+func TestIncreaseBatch(t *testing.T) {
+	client := &fakeBatchClient{
+		fakeCreateClient: fakeCreateClient{
+			fakeLimitsClient: fakeLimitsClient{limits: limits.Absolute{MaxTotalInstances: -1, MaxTotalCores: -1, MaxTotalRAMSize: -1}},
+		},
+		limit: 8,
+	}
+
+	g := &InstanceGroup{
+		Name:        "ci",
+		BatchCreate: true,
+		log:         hclog.NewNullLogger(),
+		client:      client,
+	}
+	g.ServerSpec.Name = "runner"
+	g.ServerSpec.ImageName = "flatcar"
+
+	var err error
+	g.templates, _, err = g.checkTemplates(&g.ServerSpec)
+	require.NoError(t, err)
+
+	reservationListInterval = time.Millisecond
+	defer func() { reservationListInterval = time.Second }()
+
+	succeeded, err := g.Increase(context.Background(), 10)
+	assert.Equal(t, 8, succeeded)
+	assert.EqualError(t, err, "batch created 8 of 10 instances, reservation r-1")
+	assert.Equal(t, reservationListAttempts, client.listings, "short listing is retried")
+	assert.EqualValues(t, 8, g.instanceCount.Load())
+	assert.EqualValues(t, 10, g.instanceCounter.Load())
+
+	require.Len(t, client.batches, 1)
+	assert.Empty(t, client.created)
+
+	spec := client.batches[0]
+	assert.Equal(t, "runner", spec.Name)
+	assert.Equal(t, "image-flatcar", spec.ImageRef)
+	assert.Equal(t, 1, spec.Min)
+	assert.Equal(t, 10, spec.Max)
+	assert.True(t, spec.ReturnReservationID)
+	assert.Equal(t, "ci", spec.Metadata[MetadataKey])
+
+	// single instance does not need a batch
+	succeeded, err = g.Increase(context.Background(), 1)
+	assert.Equal(t, 1, succeeded)
+	assert.NoError(t, err)
+	assert.Len(t, client.batches, 1)
+	assert.Len(t, client.created, 1)
+
+	// servers are listed with a delay after the request
+	client.limit = 10
+	client.listings = 0
+	client.delayed = 2
+	succeeded, err = g.Increase(context.Background(), 3)
+	assert.Equal(t, 3, succeeded)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, client.listings)
+
+	// no quota left, nothing to create
+	client.limits = limits.Absolute{MaxTotalInstances: 5, TotalInstancesUsed: 5, MaxTotalCores: -1, MaxTotalRAMSize: -1}
+	succeeded, err = g.Increase(context.Background(), 3)
+	assert.Equal(t, 0, succeeded)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	assert.Len(t, client.batches, 2)
+
+	// room for one instance, created without a batch
+	client.limits.TotalInstancesUsed = 4
+	succeeded, err = g.Increase(context.Background(), 3)
+	assert.Equal(t, 1, succeeded)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	assert.Len(t, client.batches, 2)
+	assert.Len(t, client.created, 2)
+}
@@ -0,0 +1,73 @@
+package fpoc
+
+import (
+	"fmt"
+
+	butane "github.com/coreos/butane/config"
+	butanecommon "github.com/coreos/butane/config/common"
+	"github.com/coreos/vcontext/report"
+	"gopkg.in/yaml.v3"
+)
+
+// IsButane checks that user data looks like Butane config (has variant and version)
+func IsButane(userData string) bool {
+	var hdr struct {
+		Variant string `yaml:"variant"`
+		Version string `yaml:"version"`
+	}
+
+	err := yaml.Unmarshal([]byte(userData), &hdr)
+	if err != nil {
+		return false
+	}
+
+	return hdr.Variant != "" && hdr.Version != ""
+}
+
+// TranspileButane converts Butane config (flatcar, fcos variants) to Ignition, returns warnings of the translation
+func TranspileButane(filesDir, src string) (ign string, warnings []string, err error) {
+	out, rpt, err := butane.TranslateBytes([]byte(src), butanecommon.TranslateBytesOptions{
+		TranslateOptions: butanecommon.TranslateOptions{FilesDir: filesDir},
+	})
+
+	for _, e := range rpt.Entries {
+		if e.Kind == report.Warn {
+			warnings = append(warnings, e.String())
+		}
+	}
+	if err != nil {
+		return "", warnings, fmt.Errorf("butane translation failed: %w: %s", err, rpt.String())
+	}
+
+	return string(out), warnings, nil
+}
+
+// transpileUserData converts Butane user data of the spec to Ignition
+func (g *InstanceGroup) transpileUserData(spec *ExtCreateOpts) error {
+	src := spec.UserDataButane
+	if src == "" && IsButane(spec.UserData) {
+		src = spec.UserData
+	} else if src != "" && spec.UserData != "" {
+		return fmt.Errorf("only one of user_data and user_data_butane may be set")
+	}
+	if src == "" {
+		return nil
+	}
+
+	if !g.UseIgnition {
+		return fmt.Errorf("butane user data requires use_ignition")
+	}
+
+	ign, warnings, err := TranspileButane(g.ButaneFilesDir, src)
+	for _, w := range warnings {
+		g.log.Warn("Butane", "warning", w)
+	}
+	if err != nil {
+		return err
+	}
+
+	spec.UserData = ign
+	spec.UserDataButane = ""
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package fpoc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsButane(t *testing.T) {
+	buf, err := os.ReadFile("heat/worker.bu.yml")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		userData string
+		expected bool
+	}{
+		{"empty", "", false},
+		{"butane", string(buf), true},
+		{"ignition", `{"ignition":{"version":"3.4.0"}}`, false},
+		{"cloud-config", "#cloud-config\npackage_update: true\n", false},
+		{"script", "#!/bin/sh\necho hello\n", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, IsButane(tc.userData))
+		})
+	}
+}
+
+func TestTranspileUserData(t *testing.T) {
+	testCases := []struct {
+		name        string
+		useIgnition bool
+		spec        ExtCreateOpts
+		expected    string
+		isErr       bool
+	}{
+		{"not-butane", true, ExtCreateOpts{UserData: `{"ignition":{"version":"3.3.0"}}`}, `{"ignition":{"version":"3.3.0"}}`, false},
+		{"user-data", true, ExtCreateOpts{UserData: "variant: flatcar\nversion: 1.1.0\n"}, `{"ignition":{"version":"3.4.0"}}`, false},
+		{"user-data-butane", true, ExtCreateOpts{UserDataButane: "variant: fcos\nversion: 1.5.0\n"}, `{"ignition":{"version":"3.4.0"}}`, false},
+		{"both", true, ExtCreateOpts{UserData: "{}", UserDataButane: "variant: fcos\nversion: 1.5.0\n"}, "", true},
+		{"cloud-init", false, ExtCreateOpts{UserDataButane: "variant: fcos\nversion: 1.5.0\n"}, "", true},
+		{"unknown-version", true, ExtCreateOpts{UserDataButane: "variant: fcos\nversion: 9.9.9\n"}, "", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			g := &InstanceGroup{
+				UseIgnition: tc.useIgnition,
+				log:         hclog.NewNullLogger(),
+			}
+
+			err := g.transpileUserData(&tc.spec)
+			if tc.isErr {
+				assert.Error(err)
+				return
+			}
+			require.NoError(t, err)
+			assert.JSONEq(tc.expected, tc.spec.UserData)
+			assert.Empty(tc.spec.UserDataButane)
+		})
+	}
+}
+
+func TestTranspileButane(t *testing.T) {
+	buf, err := os.ReadFile("heat/worker.bu.yml")
+	require.NoError(t, err)
+
+	ign, warnings, err := TranspileButane("", string(buf))
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	_, err = validateIgnition(ign)
+	require.NoError(t, err)
+	assert.Contains(t, ign, "/etc/flatcar/update.conf")
+
+	// local files are read from the files dir, unused keys are reported as warnings
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "update.conf"), []byte("SERVER=disabled\n"), 0o644))
+
+	ign, warnings, err = TranspileButane(dir, `variant: flatcar
+version: 1.1.0
+unknown: true
+storage:
+  files:
+    - path: /etc/flatcar/update.conf
+      contents:
+        local: update.conf
+`)
+	require.NoError(t, err)
+	require.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "unknown")
+	assert.Contains(t, ign, "/etc/flatcar/update.conf")
+
+	_, _, err = TranspileButane("", "variant: flatcar\nversion: 1.1.0\nstorage:\n  files:\n    - path: /etc/x\n      contents:\n        local: x\n")
+	assert.Error(t, err, "local file without files dir")
+}
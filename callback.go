@@ -0,0 +1,184 @@
+package fpoc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/hashicorp/go-hclog"
+)
+
+const callbackUnitName = "fleeting-callback.service"
+
+// CallbackConfig configures HTTP endpoint to which instances report readiness
+type CallbackConfig struct {
+	Listen string `json:"listen"` // address to listen on the manager, e.g. ":8095"
+	URL    string `json:"url"`    // base URL of the listener reachable from the instances, e.g. "http://10.0.0.10:8095"
+	After  string `json:"after"`  // optional: systemd unit after which instance calls back, default multi-user.target
+}
+
+// callbackRecord one-time token issued for an instance
+type callbackRecord struct {
+	token    string
+	serverID string
+}
+
+type callbackServer struct {
+	cfg *CallbackConfig
+	log hclog.Logger
+	srv *http.Server
+
+	mu      sync.Mutex
+	pending map[string]*callbackRecord // callback id -> record
+	ready   map[string]time.Time       // server id -> callback time
+}
+
+func newCallbackServer(cfg *CallbackConfig, log hclog.Logger) (*callbackServer, error) {
+	if cfg.Listen == "" || cfg.URL == "" {
+		return nil, fmt.Errorf("listen and url must be set")
+	}
+
+	cs := &callbackServer{
+		cfg:     cfg,
+		log:     log.Named("callback"),
+		pending: make(map[string]*callbackRecord),
+		ready:   make(map[string]time.Time),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /ready/{id}", cs.handleReady)
+
+	cs.srv = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return cs, nil
+}
+
+// start listening in the background
+func (cs *callbackServer) start() error {
+	lis, err := net.Listen("tcp", cs.cfg.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	cs.log.Info("Listening for readiness callbacks", "addr", lis.Addr().String(), "url", cs.cfg.URL)
+
+	go func() {
+		err := cs.srv.Serve(lis)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			cs.log.Error("Callback listener failed", "err", err)
+		}
+	}()
+
+	return nil
+}
+
+func (cs *callbackServer) shutdown(ctx context.Context) error {
+	return cs.srv.Shutdown(ctx)
+}
+
+// register issues one-time token for a new instance and returns the unit which calls back
+func (cs *callbackServer) register() (string, SystemdUnit, error) {
+	buf := make([]byte, 24)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", SystemdUnit{}, fmt.Errorf("failed to generate callback token: %w", err)
+	}
+
+	id := hex.EncodeToString(buf[:8])
+	token := hex.EncodeToString(buf[8:])
+	url := strings.TrimSuffix(cs.cfg.URL, "/") + "/ready/" + id
+
+	cs.mu.Lock()
+	cs.pending[id] = &callbackRecord{token: token}
+	cs.mu.Unlock()
+
+	execStart := fmt.Sprintf(`/usr/bin/curl -fsS --retry 30 --retry-connrefused -X POST -H "Authorization: Bearer %s" %s`, token, url)
+
+	return id, readinessUnit(callbackUnitName, cs.cfg.After, execStart), nil
+}
+
+// bind assigns created server to the callback
+func (cs *callbackServer) bind(id, serverID string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if rec, ok := cs.pending[id]; ok {
+		rec.serverID = serverID
+	}
+}
+
+// cancel forgets the callback if server creation failed
+func (cs *callbackServer) cancel(id string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	delete(cs.pending, id)
+}
+
+func (cs *callbackServer) isReady(serverID string) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	_, ok := cs.ready[serverID]
+	return ok
+}
+
+// prune forgets instances which no longer exist
+func (cs *callbackServer) prune(instances []servers.Server) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	alive := make(map[string]struct{}, len(instances))
+	for _, srv := range instances {
+		alive[srv.ID] = struct{}{}
+	}
+
+	for id := range cs.ready {
+		if _, ok := alive[id]; !ok {
+			delete(cs.ready, id)
+		}
+	}
+	for id, rec := range cs.pending {
+		if _, ok := alive[rec.serverID]; rec.serverID != "" && !ok {
+			delete(cs.pending, id)
+		}
+	}
+}
+
+func (cs *callbackServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	lg := cs.log.With("callback_id", id, "remote_addr", r.RemoteAddr)
+
+	cs.mu.Lock()
+	rec, ok := cs.pending[id]
+	if ok && rec.serverID != "" && subtle.ConstantTimeCompare([]byte(rec.token), []byte(token)) == 1 {
+		// token is one-time, so replays are rejected
+		delete(cs.pending, id)
+		cs.ready[rec.serverID] = time.Now()
+	} else {
+		ok = false
+	}
+	cs.mu.Unlock()
+
+	if !ok {
+		lg.Warn("Rejected readiness callback with unknown or used token")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	lg.Info("Instance called back", "server_id", rec.serverID)
+	w.WriteHeader(http.StatusNoContent)
+}
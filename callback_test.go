@@ -0,0 +1,66 @@
+package fpoc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallbackServer(t *testing.T) {
+	assert := assert.New(t)
+
+	cs, err := newCallbackServer(&CallbackConfig{Listen: "127.0.0.1:0", URL: "http://mgr:8095/"}, hclog.NewNullLogger())
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(cs.srv.Handler)
+	defer ts.Close()
+
+	id, unit, err := cs.register()
+	require.NoError(t, err)
+	assert.Equal(callbackUnitName, unit.Name)
+
+	m := regexp.MustCompile(`Bearer (\S+)" http://mgr:8095/ready/(\S+)`).FindStringSubmatch(unit.Contents)
+	require.Len(t, m, 3)
+	token := m[1]
+	assert.Equal(id, m[2])
+
+	post := func(id, token string) int {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/ready/"+id, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close() // nolint:errcheck
+
+		return resp.StatusCode
+	}
+
+	// server not created yet
+	assert.Equal(http.StatusForbidden, post(id, token))
+
+	cs.bind(id, "srv-1")
+	assert.False(cs.isReady("srv-1"))
+
+	assert.Equal(http.StatusForbidden, post(id, "wrong"))
+	assert.Equal(http.StatusForbidden, post("unknown", token))
+	assert.Equal(http.StatusNoContent, post(id, token))
+	assert.True(cs.isReady("srv-1"))
+
+	// replay
+	assert.Equal(http.StatusForbidden, post(id, token))
+
+	cs.prune([]servers.Server{{ID: "srv-2"}})
+	assert.False(cs.isReady("srv-1"))
+
+	id, _, err = cs.register()
+	require.NoError(t, err)
+	cs.cancel(id)
+	assert.Empty(cs.pending)
+}
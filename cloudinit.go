@@ -0,0 +1,207 @@
+package fpoc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+const (
+	mimeBoundary = "==FLEETING-PLUGIN-OPENSTACK=="
+
+	// append lists (runcmd, write_files) instead of replacing them
+	cloudConfigMergeType = "list(append)+dict(no_replace,recurse_list)+str()"
+)
+
+// mimePart is one part of cloud-init multipart user data
+type mimePart struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+// cloud-init part types detected by the first line
+var cloudInitContentTypes = []struct {
+	prefix      string
+	contentType string
+}{
+	{"#cloud-config-archive", "text/cloud-config-archive"},
+	{"#cloud-config", "text/cloud-config"},
+	{"#cloud-boothook", "text/cloud-boothook"},
+	{"#include", "text/x-include-url"},
+	{"#part-handler", "text/part-handler"},
+	{"## template: jinja", "text/jinja2"},
+	{"#!", "text/x-shellscript"},
+}
+
+// decodedBody returns the body with Content-Transfer-Encoding removed
+func (p mimePart) decodedBody() ([]byte, error) {
+	if !strings.EqualFold(p.header.Get("Content-Transfer-Encoding"), "base64") {
+		return p.body, nil
+	}
+
+	body, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(p.body)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode mime part: %w", err)
+	}
+
+	return body, nil
+}
+
+// IsMultipart checks that user data is already a MIME archive
+func IsMultipart(userData string) bool {
+	msg, err := mail.ReadMessage(strings.NewReader(userData))
+	if err != nil {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	return err == nil && strings.HasPrefix(mediaType, "multipart/")
+}
+
+func detectContentType(userData string) string {
+	for _, ct := range cloudInitContentTypes {
+		if strings.HasPrefix(userData, ct.prefix) {
+			return ct.contentType
+		}
+	}
+
+	return "text/plain"
+}
+
+// parseMultipart splits user data into parts, plain user data becomes a single part
+func parseMultipart(userData string) ([]mimePart, error) {
+	if strings.TrimSpace(userData) == "" {
+		return nil, nil
+	}
+
+	if !IsMultipart(userData) {
+		hdr := make(textproto.MIMEHeader)
+		hdr.Set("Content-Type", detectContentType(userData))
+
+		return []mimePart{{header: hdr, body: []byte(userData)}}, nil
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(userData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse mime: %w", err)
+	}
+
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse mime content type: %w", err)
+	}
+
+	var parts []mimePart
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		// raw part keeps Content-Transfer-Encoding untouched
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read mime part: %w", err)
+		}
+
+		body, err := io.ReadAll(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mime part: %w", err)
+		}
+
+		parts = append(parts, mimePart{header: p.Header, body: body})
+	}
+
+	return parts, nil
+}
+
+// composeMultipart writes the parts as a cloud-init MIME archive
+func composeMultipart(parts []mimePart) (string, error) {
+	var buf bytes.Buffer
+
+	boundary := mimeBoundary
+	for _, p := range parts {
+		if bytes.Contains(p.body, []byte(boundary)) {
+			boundary = multipart.NewWriter(nil).Boundary()
+			break
+		}
+	}
+
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=\"%s\"\r\nMIME-Version: 1.0\r\n\r\n", boundary)
+
+	mw := multipart.NewWriter(&buf)
+	err := mw.SetBoundary(boundary)
+	if err != nil {
+		return "", err
+	}
+
+	for _, p := range parts {
+		w, err := mw.CreatePart(p.header)
+		if err != nil {
+			return "", fmt.Errorf("failed to write mime part: %w", err)
+		}
+
+		_, err = w.Write(p.body)
+		if err != nil {
+			return "", fmt.Errorf("failed to write mime part: %w", err)
+		}
+	}
+
+	err = mw.Close()
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// appendCloudConfigPart adds plugin generated cloud-config to the user data, user parts are kept untouched
+func appendCloudConfigPart(userData, cloudConfig string) (string, error) {
+	parts, err := parseMultipart(userData)
+	if err != nil {
+		return "", err
+	}
+
+	hdr := make(textproto.MIMEHeader)
+	hdr.Set("Content-Type", `text/cloud-config; charset="utf-8"`)
+	hdr.Set("Content-Disposition", `attachment; filename="fleeting-plugin-openstack.cfg"`)
+	hdr.Set("Merge-Type", cloudConfigMergeType)
+
+	parts = append(parts, mimePart{header: hdr, body: []byte(cloudConfig)})
+
+	return composeMultipart(parts)
+}
+
+// cloudConfigs returns all #cloud-config documents of the user data
+func cloudConfigs(userData string) ([]map[string]any, error) {
+	parts, err := parseMultipart(userData)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []map[string]any
+	for _, p := range parts {
+		mediaType, _, _ := mime.ParseMediaType(p.header.Get("Content-Type"))
+		if mediaType != "text/cloud-config" {
+			continue
+		}
+
+		body, err := p.decodedBody()
+		if err != nil {
+			return nil, err
+		}
+
+		cc, err := parseCloudConfig(string(body))
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, cc)
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,127 @@
+package fpoc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/fleeting/fleeting/provider"
+
+	"github.com/sardinasystems/fleeting-plugin-openstack/internal/openstackclient"
+)
+
+const testMultipart = "Content-Type: multipart/mixed; boundary=\"===============123==\"\n" +
+	"MIME-Version: 1.0\n" +
+	"\n" +
+	"--===============123==\n" +
+	"Content-Type: text/x-shellscript; charset=\"us-ascii\"\n" +
+	"\n" +
+	"#!/bin/sh\n" +
+	"echo hello\n" +
+	"\n" +
+	"--===============123==\n" +
+	"Content-Type: text/cloud-config; charset=\"us-ascii\"\n" +
+	"Content-Transfer-Encoding: base64\n" +
+	"\n" +
+	"I2Nsb3VkLWNvbmZpZwp3cml0ZV9maWxlczoKICAtIHBhdGg6IC9ldGMvbW90ZAogICAgY29udGVudDogaGVsbG8K\n" +
+	"\n" +
+	"--===============123==--\n"
+
+func TestAppendCloudConfigPart(t *testing.T) {
+	testCases := []struct {
+		name         string
+		userData     string
+		contentTypes []string
+		firstBody    string
+	}{
+		{"empty", "", []string{}, ""},
+		{"script", "#!/bin/sh\necho hello\n", []string{"text/x-shellscript"}, "#!/bin/sh\necho hello\n"},
+		{"cloud-config", "#cloud-config\npackage_update: true\n", []string{"text/cloud-config"}, "#cloud-config\npackage_update: true\n"},
+		{"boothook", "#cloud-boothook\necho hello\n", []string{"text/cloud-boothook"}, "#cloud-boothook\necho hello\n"},
+		{"multipart", testMultipart, []string{`text/x-shellscript; charset="us-ascii"`, `text/cloud-config; charset="us-ascii"`}, "#!/bin/sh\necho hello\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			out, err := appendCloudConfigPart(tc.userData, "#cloud-config\nhostname: test\n")
+			require.NoError(t, err)
+			assert.True(IsMultipart(out))
+
+			parts, err := parseMultipart(out)
+			require.NoError(t, err)
+			require.Len(t, parts, len(tc.contentTypes)+1)
+
+			for idx, ct := range tc.contentTypes {
+				assert.Equal(ct, parts[idx].header.Get("Content-Type"))
+			}
+			if tc.firstBody != "" {
+				assert.Equal(tc.firstBody, string(parts[0].body))
+			}
+
+			last := parts[len(parts)-1]
+			assert.Equal(cloudConfigMergeType, last.header.Get("Merge-Type"))
+			assert.Equal("#cloud-config\nhostname: test\n", string(last.body))
+		})
+	}
+}
+
+func TestCloudConfigs(t *testing.T) {
+	ccs, err := cloudConfigs(testMultipart)
+	require.NoError(t, err)
+	require.Len(t, ccs, 1)
+	assert.Equal(t, []any{map[string]any{"path": "/etc/motd", "content": "hello"}}, ccs[0]["write_files"])
+
+	ccs, err = cloudConfigs("#!/bin/sh\n")
+	require.NoError(t, err)
+	assert.Empty(t, ccs)
+}
+
+func TestMergeUserDataCloudInit(t *testing.T) {
+	extras := &bootExtras{
+		Units: []SystemdUnit{
+			{Name: "test.service", Enabled: true, Contents: "[Service]\nExecStart=/bin/true\n"},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		osType   string
+		userData string
+		extras   *bootExtras
+		expected string
+	}{
+		{"windows", "windows", "#ps1\necho hello\n", extras, "#ps1\necho hello\n"},
+		{"units", "linux", "#!/bin/sh\necho hello\n", extras, "#cloud-config\nhostname: test-1\nruncmd:\n    - - systemctl\n      - enable\n      - --now\n      - --no-block\n      - test.service\nssh_authorized_keys:\n    - testkey\nwrite_files:\n    - content: |\n        [Service]\n        ExecStart=/bin/true\n      path: /etc/systemd/system/test.service\n      permissions: \"0644\"\n"},
+		{"key-only", "linux", "#!/bin/sh\necho hello\n", &bootExtras{}, "#cloud-config\nhostname: test-1\nssh_authorized_keys:\n    - testkey\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			g := &InstanceGroup{
+				settings:  provider.Settings{ConnectorConfig: provider.ConnectorConfig{Username: "test"}},
+				sshPubKey: "testkey\n",
+			}
+			g.imgProps.Store(&openstackclient.ImageProperties{OSType: tc.osType})
+
+			spec := &ExtCreateOpts{UserData: tc.userData}
+			spec.Name = "test-1"
+			err := g.mergeUserData(spec, tc.extras)
+			require.NoError(t, err)
+
+			if tc.osType == "windows" {
+				assert.Equal(tc.expected, spec.UserData)
+				return
+			}
+
+			parts, err := parseMultipart(spec.UserData)
+			require.NoError(t, err)
+			require.Len(t, parts, 2)
+			assert.Equal(tc.userData, string(parts[0].body))
+			assert.Equal(tc.expected, string(parts[1].body))
+		})
+	}
+}
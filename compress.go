@@ -0,0 +1,136 @@
+package fpoc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/vincent-petithory/dataurl"
+)
+
+// MaxUserDataSize is the Nova limit of base64 encoded user data
+const MaxUserDataSize = 65535
+
+// EncodedUserDataSize returns size of the user data as sent to Nova
+func EncodedUserDataSize(userData string) int {
+	return base64.StdEncoding.EncodedLen(len(userData))
+}
+
+func gzipBytes(buf []byte) ([]byte, error) {
+	var out bytes.Buffer
+
+	zw, err := gzip.NewWriterLevel(&out, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = zw.Write(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// compressIgnitionFiles gzips uncompressed data URL contents of the files, if that makes them smaller
+func compressIgnitionFiles(userData, version string) (string, error) {
+	cfg, err := parseIgnition(userData)
+	if err != nil {
+		return "", err
+	}
+
+	for idx := range cfg.Storage.Files {
+		f := &cfg.Storage.Files[idx]
+		src := f.Contents.Source
+		if src == nil || (f.Contents.Compression != nil && *f.Contents.Compression != "") || f.Contents.Verification.Hash != nil {
+			// keep verified contents untouched
+			continue
+		}
+
+		du, err := dataurl.DecodeString(*src)
+		if err != nil {
+			// not a data URL, fetched by Ignition
+			continue
+		}
+
+		gz, err := gzipBytes(du.Data)
+		if err != nil {
+			return "", fmt.Errorf("failed to compress %s: %w", f.Path, err)
+		}
+
+		newSrc := "data:;base64," + base64.StdEncoding.EncodeToString(gz)
+		if len(newSrc) >= len(*src) {
+			continue
+		}
+
+		compression := "gzip"
+		f.Contents.Source = &newSrc
+		f.Contents.Compression = &compression
+	}
+
+	spec := &ExtCreateOpts{}
+	err = storeIgnition(spec, cfg, version)
+	if err != nil {
+		return "", err
+	}
+
+	return spec.UserData, nil
+}
+
+// fitUserData compresses user data which exceeds Nova limit.
+// Ignition files are compressed one by one, other user data is gzipped as a whole (cloud-init and cloudbase-init detect it).
+func (g *InstanceGroup) fitUserData(userData string) (string, error) {
+	size := EncodedUserDataSize(userData)
+	if size <= MaxUserDataSize {
+		return userData, nil
+	}
+
+	var compressed string
+	if g.UseIgnition {
+		version, err := g.ignitionVersion()
+		if err != nil {
+			return "", err
+		}
+
+		compressed, err = compressIgnitionFiles(userData, version)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		gz, err := gzipBytes([]byte(userData))
+		if err != nil {
+			return "", fmt.Errorf("failed to compress user data: %w", err)
+		}
+
+		compressed = string(gz)
+	}
+
+	csize := EncodedUserDataSize(compressed)
+	if csize > MaxUserDataSize {
+		return "", fmt.Errorf("user data is too large: %d bytes encoded, %d bytes after compression, limit is %d bytes", size, csize, MaxUserDataSize)
+	}
+
+	g.log.Debug("User data compressed", "size", size, "compressed_size", csize)
+
+	return compressed, nil
+}
+
+// checkUserDataSize merges plugin generated parts into the sample and checks that the result fits Nova limit
+func (g *InstanceGroup) checkUserDataSize(sample *ExtCreateOpts) error {
+	spec := &ExtCreateOpts{UserData: sample.UserData}
+	spec.Name = sample.Name
+
+	err := g.mergeUserData(spec, g.staticExtras())
+	if err != nil {
+		return err
+	}
+
+	_, err = g.fitUserData(spec.UserData)
+	return err
+}
@@ -0,0 +1,89 @@
+package fpoc
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gunzipString(t *testing.T, s string) string {
+	zr, err := gzip.NewReader(strings.NewReader(s))
+	require.NoError(t, err)
+
+	buf, err := io.ReadAll(zr)
+	require.NoError(t, err)
+
+	return string(buf)
+}
+
+func TestFitUserData(t *testing.T) {
+	big := strings.Repeat("# some comment to make user data large\n", 2000)
+
+	random := make([]byte, 60000)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+
+	t.Run("small", func(t *testing.T) {
+		g := &InstanceGroup{log: hclog.NewNullLogger()}
+
+		ud, err := g.fitUserData("#cloud-config\n")
+		require.NoError(t, err)
+		assert.Equal(t, "#cloud-config\n", ud)
+	})
+
+	t.Run("cloud-init", func(t *testing.T) {
+		g := &InstanceGroup{log: hclog.NewNullLogger()}
+
+		ud, err := g.fitUserData("#cloud-config\n" + big)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, EncodedUserDataSize(ud), MaxUserDataSize)
+		assert.Equal(t, "#cloud-config\n"+big, gunzipString(t, ud))
+	})
+
+	t.Run("ignition", func(t *testing.T) {
+		g := &InstanceGroup{UseIgnition: true, log: hclog.NewNullLogger()}
+
+		cfg, err := parseIgnition("")
+		require.NoError(t, err)
+		insertExtrasIgn(&cfg, &bootExtras{Files: []FileSpec{
+			{Path: "/etc/big.conf", Mode: 0o644, Content: big},
+			{Path: "/etc/small.conf", Mode: 0o644, Content: "x"},
+		}})
+		spec := &ExtCreateOpts{}
+		require.NoError(t, storeIgnition(spec, cfg, ""))
+		require.Greater(t, EncodedUserDataSize(spec.UserData), MaxUserDataSize)
+
+		ud, err := g.fitUserData(spec.UserData)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, EncodedUserDataSize(ud), MaxUserDataSize)
+
+		cfg, err = parseIgnition(ud)
+		require.NoError(t, err)
+		require.Len(t, cfg.Storage.Files, 2)
+
+		f := cfg.Storage.Files[0]
+		require.NotNil(t, f.Contents.Compression)
+		assert.Equal(t, "gzip", *f.Contents.Compression)
+		gz, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(*f.Contents.Source, "data:;base64,"))
+		require.NoError(t, err)
+		assert.Equal(t, big, gunzipString(t, string(gz)))
+
+		// compression would not make it smaller
+		assert.Nil(t, cfg.Storage.Files[1].Contents.Compression)
+	})
+
+	t.Run("too-large", func(t *testing.T) {
+		g := &InstanceGroup{log: hclog.NewNullLogger()}
+
+		_, err := g.fitUserData(string(random))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "user data is too large")
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"golang.org/x/crypto/ssh"
@@ -20,7 +21,25 @@ type PrivPub interface {
 	Public() crypto.PublicKey
 }
 
-// initSSHKey prepare dynamic ssh key for flatcar instances
+// initCredentials checks the connector credentials and prepares ssh key injected into the user data
+func (g *InstanceGroup) initCredentials(ctx context.Context, log hclog.Logger, settings *provider.Settings) error {
+	switch {
+	case g.isWindows():
+		if !settings.UseStaticCredentials {
+			return fmt.Errorf("only static credentials supported for Windows images")
+		}
+		return nil
+
+	case !g.UseIgnition && settings.UseStaticCredentials && settings.Password != "":
+		// password of the image user, nothing to inject
+		return nil
+
+	default:
+		return g.initSSHKey(ctx, log, settings)
+	}
+}
+
+// initSSHKey prepare dynamic ssh key injected into Ignition or cloud-config
 func (g *InstanceGroup) initSSHKey(_ context.Context, log hclog.Logger, settings *provider.Settings) error {
 	var key PrivPub
 	var err error
@@ -65,7 +84,8 @@ func (g *InstanceGroup) initSSHKey(_ context.Context, log hclog.Logger, settings
 
 	imgProps := g.imgProps.Load()
 	if imgProps != nil {
-		if imgProps.OSAdminUser == "" && settings.Username == "" {
+		// cloud-init adds the key to the default user of the image, Ignition needs the user name
+		if imgProps.OSAdminUser == "" && settings.Username == "" && g.UseIgnition {
 			// nolint:staticcheck
 			return fmt.Errorf("image properties 'os_admin_user' and 'runners.autoscaler.connector_config.username' missing. Ensure one is set.")
 		}
@@ -76,3 +96,31 @@ func (g *InstanceGroup) initSSHKey(_ context.Context, log hclog.Logger, settings
 
 	return nil
 }
+
+// newSSHClientConfig prepares ssh client config with the connector credentials
+func newSSHClientConfig(settings *provider.Settings, timeout time.Duration) (*ssh.ClientConfig, error) {
+	cfg := &ssh.ClientConfig{
+		User: settings.Username,
+		// host keys are generated on the first boot, so we can't know them
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // nolint:gosec
+		Timeout:         timeout,
+	}
+
+	if len(settings.Key) > 0 {
+		signer, err := ssh.ParsePrivateKey(settings.Key)
+		if err != nil {
+			return nil, fmt.Errorf("reading private key: %w", err)
+		}
+
+		cfg.Auth = append(cfg.Auth, ssh.PublicKeys(signer))
+	}
+	if settings.Password != "" {
+		cfg.Auth = append(cfg.Auth, ssh.Password(settings.Password))
+	}
+
+	if len(cfg.Auth) == 0 {
+		return nil, fmt.Errorf("no ssh key or password in the connector config")
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,74 @@
+package fpoc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/sardinasystems/fleeting-plugin-openstack/internal/openstackclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/fleeting/fleeting/provider"
+)
+
+func TestInitCredentials(t *testing.T) {
+	// connector key, dynamic key generation is slow
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	key := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+
+	testCases := []struct {
+		name        string
+		useIgnition bool
+		imgProps    *openstackclient.ImageProperties
+		settings    provider.Settings
+		username    string
+		injectKey   bool
+		isErr       bool
+	}{
+		{"windows", false, &openstackclient.ImageProperties{OSType: "windows"}, provider.Settings{ConnectorConfig: provider.ConnectorConfig{UseStaticCredentials: true, Password: "pw"}}, "", false, false},
+		{"windows-dynamic", false, &openstackclient.ImageProperties{OSType: "windows"}, provider.Settings{}, "", false, true},
+		{"cloud-init-password", false, &openstackclient.ImageProperties{}, provider.Settings{ConnectorConfig: provider.ConnectorConfig{UseStaticCredentials: true, Password: "pw"}}, "", false, false},
+		{"cloud-init-no-user", false, &openstackclient.ImageProperties{}, provider.Settings{}, "", true, false},
+		{"cloud-init-admin-user", false, &openstackclient.ImageProperties{OSAdminUser: "ubuntu"}, provider.Settings{}, "ubuntu", true, false},
+		{"ignition-no-user", true, &openstackclient.ImageProperties{}, provider.Settings{}, "", false, true},
+		{"ignition-user", true, &openstackclient.ImageProperties{}, provider.Settings{ConnectorConfig: provider.ConnectorConfig{Username: "core"}}, "core", true, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := &InstanceGroup{UseIgnition: tc.useIgnition}
+			g.imgProps.Store(tc.imgProps)
+
+			settings := tc.settings
+			if !settings.UseStaticCredentials {
+				settings.Key = key
+			}
+			err := g.initCredentials(context.TODO(), hclog.NewNullLogger(), &settings)
+			if tc.isErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.username, settings.Username)
+			assert.Equal(t, tc.injectKey, g.sshPubKey != "")
+		})
+	}
+}
+
+func TestInitImageProperties(t *testing.T) {
+	client := &fakeCreateClient{}
+	g := &InstanceGroup{client: client}
+	g.ServerSpec.ImageName = "flatcar"
+
+	err := g.initImageProperties(context.TODO())
+	require.NoError(t, err)
+	assert.NotNil(t, g.imgProps.Load())
+	assert.EqualValues(t, 1, client.imageLookup.Load())
+}
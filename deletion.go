@@ -0,0 +1,166 @@
+package fpoc
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2"
+)
+
+const (
+	defaultDeleteTimeout = 5 * time.Minute
+	deleteMaxAttempts    = 3
+)
+
+// deletePollInterval interval between checks of the deleted server
+var deletePollInterval = 10 * time.Second
+
+// deletionTracker confirms in the background that deleted servers are gone
+type deletionTracker struct {
+	mu      sync.Mutex
+	pending map[string]struct{}
+}
+
+// start returns false if the server is already tracked
+func (dt *deletionTracker) start(id string) bool {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	if dt.pending == nil {
+		dt.pending = make(map[string]struct{})
+	}
+	if _, ok := dt.pending[id]; ok {
+		return false
+	}
+
+	dt.pending[id] = struct{}{}
+	return true
+}
+
+func (dt *deletionTracker) done(id string) {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	delete(dt.pending, id)
+}
+
+// forEachParallel calls fn for each index, at most limit calls run concurrently
+func forEachParallel(count, limit int, fn func(idx int)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(limit, 1))
+
+	for idx := 0; idx < count; idx++ {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			fn(idx)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// confirmDeletion polls the server until it's gone, deletion is retried if it stalls
+func (g *InstanceGroup) confirmDeletion(id string) {
+	if !g.deletions.start(id) {
+		return
+	}
+
+	b, serverID, err := g.route(id)
+	if err != nil {
+		g.deletions.done(id)
+		return
+	}
+
+	go func() {
+		defer g.deletions.done(id)
+
+		ctx := g.backgroundCtx()
+		lg := g.log.With("server_id", id)
+		start := time.Now()
+		deadline := start.Add(g.DeleteTimeout)
+
+		ticker := time.NewTicker(deletePollInterval)
+		defer ticker.Stop()
+
+		for attempt := 1; ; {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			srv, err := b.client.GetServer(ctx, serverID)
+			if gophercloud.ResponseCodeIs(err, http.StatusNotFound) {
+				lg.Debug("Instance deletion confirmed", "duration", time.Since(start))
+				return
+			} else if err != nil {
+				lg.Warn("Failed to check deleted instance", "err", err)
+				continue
+			}
+
+			if time.Now().Before(deadline) {
+				continue
+			}
+
+			faultLg := lg.With("status", srv.Status, "fault_code", srv.Fault.Code, "fault_message", srv.Fault.Message)
+			if srv.Fault.Details != "" {
+				faultLg = faultLg.With("fault_details", srv.Fault.Details)
+			}
+
+			if attempt >= deleteMaxAttempts {
+				faultLg.Error("Instance still exists after deletion retries, giving up", "attempts", attempt, "duration", time.Since(start))
+				return
+			}
+
+			attempt++
+			deadline = time.Now().Add(g.DeleteTimeout)
+
+			faultLg.Warn("Instance deletion stalled, retrying", "attempt", attempt)
+			err = b.client.DeleteServer(ctx, serverID)
+			if err != nil && !gophercloud.ResponseCodeIs(err, http.StatusNotFound) {
+				faultLg.Warn("Failed to retry instance deletion", "err", err)
+			}
+		}
+	}()
+}
+
+// deleteInstances sends delete requests concurrently, accepted deletions are confirmed in the background
+func (g *InstanceGroup) deleteInstances(ctx context.Context, instances []string) (succeeded []string, errs []error) {
+	var mu sync.Mutex
+
+	forEachParallel(len(instances), g.MaxParallelDeletes, func(idx int) {
+		id := instances[idx]
+		lg := g.log.With("id", id)
+
+		b, serverID, err := g.route(id)
+		if err == nil {
+			err = b.client.DeleteServer(ctx, serverID)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if gophercloud.ResponseCodeIs(err, http.StatusNotFound) {
+			lg.Info("Instance already deleted")
+			succeeded = append(succeeded, id)
+			return
+		} else if err != nil {
+			lg.Error("Failed to delete instance", "err", err)
+			errs = append(errs, err)
+			return
+		}
+
+		lg.Info("Instance deletion request successful")
+		succeeded = append(succeeded, id)
+		g.confirmDeletion(id)
+	})
+
+	return succeeded, errs
+}
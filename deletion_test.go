@@ -0,0 +1,93 @@
+package fpoc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDeleteClient servers named "stuck" never go away, "broken" can't be deleted
+type fakeDeleteClient struct {
+	fakeLimitsClient
+
+	mu      sync.Mutex
+	deletes map[string]int
+}
+
+func (c *fakeDeleteClient) DeleteServer(ctx context.Context, serverId string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deletes[serverId]++
+	switch serverId {
+	case "broken":
+		return errors.New("delete failed")
+	case "gone":
+		return gophercloud.ErrUnexpectedResponseCode{Actual: http.StatusNotFound}
+	}
+
+	return nil
+}
+
+func (c *fakeDeleteClient) GetServer(ctx context.Context, serverId string) (*servers.Server, error) {
+	if serverId == "stuck" {
+		return &servers.Server{ID: serverId, Status: "ERROR", Fault: servers.Fault{Code: 500, Message: "volume detach failed"}}, nil
+	}
+
+	return nil, gophercloud.ErrUnexpectedResponseCode{Actual: http.StatusNotFound}
+}
+
+func (c *fakeDeleteClient) count(id string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.deletes[id]
+}
+
+func TestDecrease(t *testing.T) {
+	oldInterval := deletePollInterval
+	deletePollInterval = 5 * time.Millisecond
+	defer func() { deletePollInterval = oldInterval }()
+
+	var logBuf bytes.Buffer
+	var logMu sync.Mutex
+	client := &fakeDeleteClient{deletes: make(map[string]int)}
+
+	g := &InstanceGroup{
+		MaxParallelDeletes: 2,
+		DeleteTimeout:      time.Millisecond,
+		client:             client,
+		log:                hclog.New(&hclog.LoggerOptions{Output: &logBuf, Mutex: &logMu, Level: hclog.Debug}),
+	}
+	g.bgCtx, g.bgCancel = context.WithCancel(context.Background())
+	defer g.bgCancel()
+
+	succeeded, err := g.Decrease(context.Background(), []string{"ok", "stuck", "broken", "gone"})
+	assert.ElementsMatch(t, []string{"ok", "stuck", "gone"}, succeeded)
+	assert.ErrorContains(t, err, "delete failed")
+
+	require.Eventually(t, func() bool {
+		g.deletions.mu.Lock()
+		defer g.deletions.mu.Unlock()
+		return len(g.deletions.pending) == 0
+	}, 5*time.Second, 5*time.Millisecond)
+
+	assert.Equal(t, 1, client.count("ok"))
+	assert.Equal(t, deleteMaxAttempts, client.count("stuck"))
+	assert.Equal(t, 1, client.count("gone"))
+
+	logMu.Lock()
+	defer logMu.Unlock()
+	assert.Contains(t, logBuf.String(), "Instance still exists after deletion retries, giving up")
+	assert.Contains(t, logBuf.String(), "fault_message=\"volume detach failed\"")
+}
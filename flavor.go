@@ -0,0 +1,196 @@
+package fpoc
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+)
+
+// FlavorMetadataKey server metadata key of the flavor used for the instance
+const FlavorMetadataKey = "fleeting-flavor"
+
+// noValidHostFault message of the scheduling failure fault
+const noValidHostFault = "No valid host was found"
+
+// fallbackReplacement instance to create with the next flavor in place of the one failed to schedule
+type fallbackReplacement struct {
+	backend   *backend
+	flavorRef string
+}
+
+// fallbackTracker remembers failed servers already replaced with the next flavor
+// and queues their replacements for the next Increase
+type fallbackTracker struct {
+	mu       sync.Mutex
+	replaced map[string]struct{}
+	pending  []fallbackReplacement
+}
+
+// claim returns false if the server is already replaced
+func (ft *fallbackTracker) claim(id string) bool {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	if ft.replaced == nil {
+		ft.replaced = make(map[string]struct{})
+	}
+	if _, ok := ft.replaced[id]; ok {
+		return false
+	}
+
+	ft.replaced[id] = struct{}{}
+	return true
+}
+
+func (ft *fallbackTracker) release(id string) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	delete(ft.replaced, id)
+}
+
+// queue adds the replacement created by the next Increase
+func (ft *fallbackTracker) queue(r fallbackReplacement) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	ft.pending = append(ft.pending, r)
+}
+
+// requeue queues replacements of the plan again, e.g. not created because of the quota
+func (ft *fallbackTracker) requeue(plan []plannedInstance) {
+	for _, p := range plan {
+		if p.flavorRef != "" {
+			ft.queue(fallbackReplacement{backend: p.backend, flavorRef: p.flavorRef})
+		}
+	}
+}
+
+// take removes up to n queued replacements
+func (ft *fallbackTracker) take(n int) []fallbackReplacement {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	n = min(n, len(ft.pending))
+	ret := slices.Clone(ft.pending[:n])
+	ft.pending = ft.pending[n:]
+
+	return ret
+}
+
+func (ft *fallbackTracker) isReplaced(id string) bool {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	_, ok := ft.replaced[id]
+	return ok
+}
+
+// prune forgets instances which no longer exist
+func (ft *fallbackTracker) prune(instances []servers.Server) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	alive := make(map[string]struct{}, len(instances))
+	for _, srv := range instances {
+		alive[srv.ID] = struct{}{}
+	}
+
+	for id := range ft.replaced {
+		if _, ok := alive[id]; !ok {
+			delete(ft.replaced, id)
+		}
+	}
+}
+
+// flavorArchitecture returns architecture required by the flavor extra specs, empty if not set
+func flavorArchitecture(extraSpecs map[string]string) string {
+	if arch := extraSpecs["capabilities:cpu_arch"]; arch != "" {
+		return arch
+	}
+
+	for k, v := range extraSpecs {
+		if arch, ok := strings.CutPrefix(k, "trait:HW_ARCH_"); ok && v == "required" {
+			return strings.ToLower(arch)
+		}
+	}
+
+	return ""
+}
+
+// initFlavors checks flavorRefs of the spec and reads architecture of each flavor
+func (g *InstanceGroup) initFlavors(ctx context.Context) error {
+	spec := &g.ServerSpec
+	if len(spec.FlavorRefs) == 0 {
+		return nil
+	}
+
+	if spec.FlavorRef != "" && spec.FlavorRef != spec.FlavorRefs[0] {
+		return fmt.Errorf("only one of flavorRef and flavorRefs may be set")
+	}
+	if slices.Contains(spec.FlavorRefs, "") {
+		return fmt.Errorf("flavorRefs must not have empty entries")
+	}
+
+	spec.FlavorRef = spec.FlavorRefs[0]
+
+	g.flavorArch = make(map[string]string, len(spec.FlavorRefs))
+	for _, ref := range spec.FlavorRefs {
+		flavor, err := g.client.GetFlavor(ctx, ref)
+		if err != nil {
+			return err
+		}
+
+		g.flavorArch[ref] = flavorArchitecture(flavor.ExtraSpecs)
+	}
+
+	return nil
+}
+
+// nextFlavor returns the flavor following the one used for the server, empty if none left
+func (g *InstanceGroup) nextFlavor(srv *servers.Server) string {
+	refs := g.ServerSpec.FlavorRefs
+
+	idx := slices.Index(refs, srv.Metadata[FlavorMetadataKey])
+	if idx < 0 || idx+1 >= len(refs) {
+		return ""
+	}
+
+	return refs[idx+1]
+}
+
+// fallbackFlavor deletes the server failed to schedule and queues its replacement with the next flavor,
+// returns false if it's not possible
+func (g *InstanceGroup) fallbackFlavor(ctx context.Context, srv *servers.Server) bool {
+	if !strings.Contains(srv.Fault.Message, noValidHostFault) {
+		return false
+	}
+
+	flavorRef := g.nextFlavor(srv)
+	if flavorRef == "" || !g.fallbacks.claim(srv.ID) {
+		return false
+	}
+
+	// replacement is created in the same backend
+	b, _, err := g.route(srv.ID)
+	if err != nil {
+		g.fallbacks.release(srv.ID)
+		return false
+	}
+
+	_, errs := g.deleteInstances(ctx, []string{srv.ID})
+	if len(errs) > 0 {
+		g.fallbacks.release(srv.ID)
+		return false
+	}
+
+	g.fallbacks.queue(fallbackReplacement{backend: b, flavorRef: flavorRef})
+	g.log.Info("Instance failed to schedule, replacement with the next flavor queued",
+		"server_id", srv.ID, "flavor", srv.Metadata[FlavorMetadataKey], "next_flavor", flavorRef)
+
+	return true
+}
@@ -0,0 +1,159 @@
+package fpoc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/flavors"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/limits"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/fleeting/fleeting/provider"
+)
+
+func TestFlavorArchitecture(t *testing.T) {
+	testCases := []struct {
+		name       string
+		extraSpecs map[string]string
+		expected   string
+	}{
+		{"none", nil, ""},
+		{"capabilities", map[string]string{"capabilities:cpu_arch": "aarch64"}, "aarch64"},
+		{"trait", map[string]string{"trait:HW_ARCH_AARCH64": "required"}, "aarch64"},
+		{"forbidden trait", map[string]string{"trait:HW_ARCH_AARCH64": "forbidden"}, ""},
+		{"other", map[string]string{"hw:cpu_policy": "dedicated"}, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, flavorArchitecture(tc.extraSpecs))
+		})
+	}
+}
+
+// fakeFallbackClient lists static servers, records deletions
+type fakeFallbackClient struct {
+	fakeCreateClient
+
+	servers []servers.Server
+	listErr error
+	deleted []string
+	flavors map[string]flavors.Flavor
+}
+
+func (c *fakeFallbackClient) GetFlavor(ctx context.Context, flavorRef string) (*flavors.Flavor, error) {
+	if flavor, ok := c.flavors[flavorRef]; ok {
+		return &flavor, nil
+	}
+
+	return c.fakeCreateClient.GetFlavor(ctx, flavorRef)
+}
+
+func (c *fakeFallbackClient) ListServers(ctx context.Context) ([]servers.Server, error) {
+	return c.servers, c.listErr
+}
+
+func (c *fakeFallbackClient) DeleteServer(ctx context.Context, serverId string) error {
+	c.deleted = append(c.deleted, serverId)
+	return nil
+}
+
+func TestUpdateFlavorFallback(t *testing.T) {
+	noValidHost := servers.Fault{Code: 500, Message: "No valid host was found. There are not enough hosts available."}
+	metadata := func(flavor string) map[string]string {
+		return map[string]string{MetadataKey: "ci", FlavorMetadataKey: flavor}
+	}
+
+	client := &fakeFallbackClient{
+		servers: []servers.Server{
+			{ID: "first", Status: "ERROR", Fault: noValidHost, Metadata: metadata("large")},
+			{ID: "last", Status: "ERROR", Fault: noValidHost, Metadata: metadata("medium")},
+			{ID: "other", Status: "ERROR", Fault: servers.Fault{Code: 500, Message: "Build of instance aborted"}, Metadata: metadata("large")},
+		},
+		flavors: map[string]flavors.Flavor{"large": {VCPUs: 8}, "medium": {VCPUs: 4}},
+	}
+	// the replacement fits into the cores quota with the medium flavor only
+	client.limits = limits.Absolute{MaxTotalInstances: -1, MaxTotalCores: 4, MaxTotalRAMSize: -1}
+
+	g := &InstanceGroup{
+		Name:   "ci",
+		log:    hclog.NewNullLogger(),
+		client: client,
+	}
+	g.bgCtx, g.bgCancel = context.WithCancel(context.Background())
+	defer g.bgCancel()
+
+	g.ServerSpec.Name = "runner-%d"
+	g.ServerSpec.FlavorRefs = []string{"large", "medium"}
+	g.ServerSpec.FlavorRef = "large"
+
+	var err error
+	g.templates, _, err = g.checkTemplates(&g.ServerSpec)
+	require.NoError(t, err)
+
+	for range 2 {
+		states := make(map[string]provider.State)
+		err = g.Update(context.Background(), func(id string, state provider.State) {
+			states[id] = state
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, map[string]provider.State{
+			"first": provider.StateDeleting,
+			"last":  provider.StateTimeout,
+			"other": provider.StateTimeout,
+		}, states)
+	}
+
+	assert.Equal(t, []string{"first"}, client.deleted)
+	assert.Empty(t, client.created, "replacement is created by Increase")
+
+	// no cores left: the replacement waits for the next Increase
+	client.limits.TotalCoresUsed = 4
+	succeeded, err := g.Increase(context.Background(), 1)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	assert.Equal(t, 0, succeeded)
+
+	client.limits.TotalCoresUsed = 0
+	succeeded, err = g.Increase(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, succeeded)
+	assert.Empty(t, g.fallbacks.take(1))
+
+	require.Len(t, client.created, 1)
+	assert.Equal(t, "medium", client.created[0].FlavorRef)
+	assert.Equal(t, "medium", client.created[0].Metadata[FlavorMetadataKey])
+}
+
+func TestConnectInfoFlavorArch(t *testing.T) {
+	g := &InstanceGroup{
+		log:        hclog.NewNullLogger(),
+		flavorArch: map[string]string{"large": "", "arm": "aarch64"},
+	}
+
+	for flavor, arch := range map[string]string{"large": "amd64", "arm": "arm64"} {
+		g.client = &fakeServerClient{srv: servers.Server{
+			ID:         "srv",
+			Status:     "ACTIVE",
+			AccessIPv4: "10.0.0.1",
+			Metadata:   map[string]string{FlavorMetadataKey: flavor},
+		}}
+
+		info, err := g.ConnectInfo(context.Background(), "srv")
+		require.NoError(t, err)
+		assert.Equal(t, arch, info.Arch, flavor)
+	}
+}
+
+// fakeServerClient returns the static server
+type fakeServerClient struct {
+	fakeLimitsClient
+
+	srv servers.Server
+}
+
+func (c *fakeServerClient) GetServer(ctx context.Context, serverId string) (*servers.Server, error) {
+	return &c.srv, nil
+}
@@ -0,0 +1,120 @@
+package fpoc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/coreos/go-semver/semver"
+	v3_0 "github.com/coreos/ignition/v2/config/v3_0"
+	v3_1 "github.com/coreos/ignition/v2/config/v3_1"
+	v3_2 "github.com/coreos/ignition/v2/config/v3_2"
+	v3_3 "github.com/coreos/ignition/v2/config/v3_3"
+	igncfg "github.com/coreos/ignition/v2/config/v3_4"
+	igntyp "github.com/coreos/ignition/v2/config/v3_4/types"
+	"github.com/coreos/vcontext/report"
+)
+
+// ignitionParsers parse config of the exact spec version, older than the one used internally
+var ignitionParsers = map[string]func([]byte) (any, report.Report, error){
+	"3.0.0": func(raw []byte) (any, report.Report, error) { return v3_0.Parse(raw) },
+	"3.1.0": func(raw []byte) (any, report.Report, error) { return v3_1.Parse(raw) },
+	"3.2.0": func(raw []byte) (any, report.Report, error) { return v3_2.Parse(raw) },
+	"3.3.0": func(raw []byte) (any, report.Report, error) { return v3_3.Parse(raw) },
+}
+
+// ParseIgnitionVersion normalizes spec version, e.g. 3.3 -> 3.3.0, and checks that it's supported
+func ParseIgnitionVersion(version string) (string, error) {
+	if version == "" {
+		return "", nil
+	}
+
+	if strings.Count(version, ".") == 1 {
+		version += ".0"
+	}
+
+	ver, err := semver.NewVersion(version)
+	if err != nil {
+		return "", fmt.Errorf("invalid ignition version %q: %w", version, err)
+	}
+
+	ret := ver.String()
+	if _, ok := ignitionParsers[ret]; !ok && ret != igntyp.MaxVersion.String() {
+		return "", fmt.Errorf("unsupported ignition version %s, supported 3.0.0 - %s", ret, igntyp.MaxVersion.String())
+	}
+
+	return ret, nil
+}
+
+// ignitionVersion returns target spec version: option, or ignition_version property of the image
+func (g *InstanceGroup) ignitionVersion() (string, error) {
+	if g.IgnitionVersion != "" {
+		return ParseIgnitionVersion(g.IgnitionVersion)
+	}
+
+	imgProps := g.imgProps.Load()
+	if imgProps == nil || imgProps.IgnitionVersion == "" {
+		return "", nil
+	}
+
+	ver, err := ParseIgnitionVersion(imgProps.IgnitionVersion)
+	if err != nil {
+		return "", fmt.Errorf("image property ignition_version: %w", err)
+	}
+
+	return ver, nil
+}
+
+// translateIgnition encodes the config as spec version, empty version means the latest one.
+// Config is translated back to check that no features were lost.
+func translateIgnition(cfg igntyp.Config, version string) ([]byte, error) {
+	cfg.Ignition.Version = igntyp.MaxVersion.String()
+
+	orig, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal ignition: %w", err)
+	}
+
+	if version == "" || version == igntyp.MaxVersion.String() {
+		return orig, nil
+	}
+
+	parse, ok := ignitionParsers[version]
+	if !ok {
+		return nil, fmt.Errorf("unsupported ignition version %s", version)
+	}
+
+	cfg.Ignition.Version = version
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal ignition: %w", err)
+	}
+
+	oldCfg, rpt, err := parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to translate ignition to %s: %w: %s", version, err, rpt.String())
+	}
+
+	out, err := json.Marshal(oldCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal ignition: %w", err)
+	}
+
+	back, _, err := igncfg.ParseCompatibleVersion(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to translate ignition from %s: %w", version, err)
+	}
+	back.Ignition.Version = igntyp.MaxVersion.String()
+
+	backRaw, err := json.Marshal(back)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal ignition: %w", err)
+	}
+
+	if !bytes.Equal(orig, backRaw) {
+		return nil, fmt.Errorf("ignition config uses features not supported by spec %s: %s", version, strings.TrimSpace(rpt.String()))
+	}
+
+	return out, nil
+}
@@ -0,0 +1,75 @@
+package fpoc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIgnitionVersion(t *testing.T) {
+	testCases := []struct {
+		version  string
+		expected string
+		isErr    bool
+	}{
+		{"", "", false},
+		{"3.3", "3.3.0", false},
+		{"3.2.0", "3.2.0", false},
+		{"3.4", "3.4.0", false},
+		{"3.5.0", "", true},
+		{"2.3.0", "", true},
+		{"latest", "", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.version, func(t *testing.T) {
+			ver, err := ParseIgnitionVersion(tc.version)
+			if tc.isErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, ver)
+		})
+	}
+}
+
+func TestTranslateIgnition(t *testing.T) {
+	extras := &bootExtras{
+		Files: []FileSpec{{Path: "/etc/test.conf", Mode: 0o644, Content: "test\n"}},
+		Units: []SystemdUnit{{Name: "test.service", Enabled: true, Contents: "[Service]\nExecStart=/bin/true\n"}},
+	}
+
+	testCases := []struct {
+		name     string
+		userData string
+		version  string
+		expected string
+		isErr    bool
+	}{
+		{"latest", `{"ignition":{"version":"3.2.0"}}`, "", `{"ignition":{"config":{"replace":{"verification":{}}},"proxy":{},"security":{"tls":{}},"timeouts":{},"version":"3.4.0"},"kernelArguments":{},"passwd":{"users":[{"name":"core","sshAuthorizedKeys":["testkey"]}]},"storage":{"files":[{"group":{},"overwrite":true,"path":"/etc/test.conf","user":{},"contents":{"source":"data:;base64,dGVzdAo=","verification":{}},"mode":420}]},"systemd":{"units":[{"contents":"[Service]\nExecStart=/bin/true\n","enabled":true,"name":"test.service"}]}}`, false},
+		{"down-3.2", `{"ignition":{"version":"3.4.0"}}`, "3.2.0", `{"ignition":{"config":{"replace":{"verification":{}}},"proxy":{},"security":{"tls":{}},"timeouts":{},"version":"3.2.0"},"passwd":{"users":[{"name":"core","sshAuthorizedKeys":["testkey"]}]},"storage":{"files":[{"group":{},"overwrite":true,"path":"/etc/test.conf","user":{},"contents":{"source":"data:;base64,dGVzdAo=","verification":{}},"mode":420}]},"systemd":{"units":[{"contents":"[Service]\nExecStart=/bin/true\n","enabled":true,"name":"test.service"}]}}`, false},
+		{"down-3.0", `{"ignition":{"version":"3.1.0"}}`, "3.0.0", `{"ignition":{"config":{"replace":{"source":null,"verification":{}}},"security":{"tls":{}},"timeouts":{},"version":"3.0.0"},"passwd":{"users":[{"name":"core","sshAuthorizedKeys":["testkey"]}]},"storage":{"files":[{"group":{},"overwrite":true,"path":"/etc/test.conf","user":{},"contents":{"source":"data:;base64,dGVzdAo=","verification":{}},"mode":420}]},"systemd":{"units":[{"contents":"[Service]\nExecStart=/bin/true\n","enabled":true,"name":"test.service"}]}}`, false},
+		{"kargs-3.2", `{"ignition":{"version":"3.3.0"},"kernelArguments":{"shouldExist":["quiet"]}}`, "3.2.0", "", true},
+		{"kargs-3.3", `{"ignition":{"version":"3.3.0"},"kernelArguments":{"shouldExist":["quiet"]}}`, "3.3.0", `{"ignition":{"config":{"replace":{"verification":{}}},"proxy":{},"security":{"tls":{}},"timeouts":{},"version":"3.3.0"},"kernelArguments":{"shouldExist":["quiet"]},"passwd":{"users":[{"name":"core","sshAuthorizedKeys":["testkey"]}]},"storage":{"files":[{"group":{},"overwrite":true,"path":"/etc/test.conf","user":{},"contents":{"source":"data:;base64,dGVzdAo=","verification":{}},"mode":420}]},"systemd":{"units":[{"contents":"[Service]\nExecStart=/bin/true\n","enabled":true,"name":"test.service"}]}}`, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := parseIgnition(tc.userData)
+			require.NoError(t, err)
+
+			insertSSHKeyIgn(&cfg, "core", "testkey")
+			insertExtrasIgn(&cfg, extras)
+
+			buf, err := translateIgnition(cfg, tc.version)
+			if tc.isErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, string(buf))
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package openstackclient
+
+import (
+	"context"
+	"sync"
+
+	volumelimits "github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/limits"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/flavors"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/limits"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servergroups"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+)
+
+// lazyClient authenticates on the first request, failed authentication is retried by the next one
+type lazyClient struct {
+	authConfig AuthConfig
+	cloudOpts  *CloudOpts
+
+	mu     sync.Mutex
+	client Client
+}
+
+// NewLazy returns client which connects to the cloud on the first request, so unavailable cloud does not fail the caller
+func NewLazy(authConfig AuthConfig, cloudOpts *CloudOpts) Client {
+	return &lazyClient{authConfig: authConfig, cloudOpts: cloudOpts}
+}
+
+func (c *lazyClient) get(ctx context.Context) (Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.client != nil {
+		return c.client, nil
+	}
+
+	client, err := New(ctx, c.authConfig, c.cloudOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	c.client = client
+	return client, nil
+}
+
+func (c *lazyClient) GetImageProperties(ctx context.Context, imageRef string) (*ImageProperties, error) {
+	client, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.GetImageProperties(ctx, imageRef)
+}
+
+func (c *lazyClient) GetImageByName(ctx context.Context, imageName string) (string, *ImageProperties, error) {
+	client, err := c.get(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return client.GetImageByName(ctx, imageName)
+}
+
+func (c *lazyClient) ShowServerConsoleOutput(ctx context.Context, serverId string) (string, error) {
+	client, err := c.get(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return client.ShowServerConsoleOutput(ctx, serverId)
+}
+
+func (c *lazyClient) GetServer(ctx context.Context, serverId string) (*servers.Server, error) {
+	client, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.GetServer(ctx, serverId)
+}
+
+func (c *lazyClient) ListServers(ctx context.Context) ([]servers.Server, error) {
+	client, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.ListServers(ctx)
+}
+
+func (c *lazyClient) CreateServer(ctx context.Context, spec servers.CreateOptsBuilder, hintOpts servers.SchedulerHintOptsBuilder) (*servers.Server, error) {
+	client, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.CreateServer(ctx, spec, hintOpts)
+}
+
+func (c *lazyClient) CreateServers(ctx context.Context, spec servers.CreateOptsBuilder, hintOpts servers.SchedulerHintOptsBuilder) (string, error) {
+	client, err := c.get(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return client.CreateServers(ctx, spec, hintOpts)
+}
+
+func (c *lazyClient) ListServersByReservation(ctx context.Context, reservationId string) ([]servers.Server, error) {
+	client, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.ListServersByReservation(ctx, reservationId)
+}
+
+func (c *lazyClient) DeleteServer(ctx context.Context, serverId string) error {
+	client, err := c.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	return client.DeleteServer(ctx, serverId)
+}
+
+func (c *lazyClient) GetLimits(ctx context.Context) (*limits.Absolute, error) {
+	client, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.GetLimits(ctx)
+}
+
+func (c *lazyClient) GetFlavor(ctx context.Context, flavorRef string) (*flavors.Flavor, error) {
+	client, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.GetFlavor(ctx, flavorRef)
+}
+
+func (c *lazyClient) GetVolumeLimits(ctx context.Context) (*volumelimits.Absolute, error) {
+	client, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.GetVolumeLimits(ctx)
+}
+
+func (c *lazyClient) ListServerGroups(ctx context.Context) ([]servergroups.ServerGroup, error) {
+	client, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.ListServerGroups(ctx)
+}
+
+func (c *lazyClient) CreateServerGroup(ctx context.Context, opts servergroups.CreateOptsBuilder) (*servergroups.ServerGroup, error) {
+	client, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.CreateServerGroup(ctx, opts)
+}
+
+func (c *lazyClient) DeleteServerGroup(ctx context.Context, groupId string) error {
+	client, err := c.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	return client.DeleteServerGroup(ctx, groupId)
+}
@@ -5,11 +5,16 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/caarlos0/env/v11"
 	"github.com/go-viper/mapstructure/v2"
 	"github.com/gophercloud/gophercloud/v2"
 	"github.com/gophercloud/gophercloud/v2/openstack"
+	volumelimits "github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/limits"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/flavors"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/limits"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servergroups"
 	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
 	"github.com/gophercloud/gophercloud/v2/openstack/config"
 	"github.com/gophercloud/gophercloud/v2/openstack/config/clouds"
@@ -71,6 +76,9 @@ type ImageProperties struct {
 
 	// OSAdminUser is the default admin user name for the operating system
 	OSAdminUser string `json:"os_admin_user,omitempty" mapstructure:"os_admin_user,omitempty"`
+
+	// IgnitionVersion is the latest Ignition spec version supported by the image (not a standard property)
+	IgnitionVersion string `json:"ignition_version,omitempty" mapstructure:"ignition_version,omitempty"`
 }
 
 type Client interface {
@@ -80,12 +88,21 @@ type Client interface {
 	GetServer(ctx context.Context, serverId string) (*servers.Server, error)
 	ListServers(ctx context.Context) ([]servers.Server, error)
 	CreateServer(ctx context.Context, spec servers.CreateOptsBuilder, hintOpts servers.SchedulerHintOptsBuilder) (*servers.Server, error)
+	CreateServers(ctx context.Context, spec servers.CreateOptsBuilder, hintOpts servers.SchedulerHintOptsBuilder) (string, error)
+	ListServersByReservation(ctx context.Context, reservationId string) ([]servers.Server, error)
 	DeleteServer(ctx context.Context, serverId string) error
+	GetLimits(ctx context.Context) (*limits.Absolute, error)
+	GetFlavor(ctx context.Context, flavorRef string) (*flavors.Flavor, error)
+	GetVolumeLimits(ctx context.Context) (*volumelimits.Absolute, error)
+	ListServerGroups(ctx context.Context) ([]servergroups.ServerGroup, error)
+	CreateServerGroup(ctx context.Context, opts servergroups.CreateOptsBuilder) (*servergroups.ServerGroup, error)
+	DeleteServerGroup(ctx context.Context, groupId string) error
 }
 
 type client struct {
 	compute *gophercloud.ServiceClient
 	image   *gophercloud.ServiceClient
+	volume  *gophercloud.ServiceClient // nil if the cloud has no block storage
 }
 
 func New(ctx context.Context, authConfig AuthConfig, cloudOpts *CloudOpts) (Client, error) {
@@ -119,9 +136,16 @@ func New(ctx context.Context, authConfig AuthConfig, cloudOpts *CloudOpts) (Clie
 		return nil, err
 	}
 
+	// block storage is optional, only used to check volume quota
+	volumeClient, err := openstack.NewBlockStorageV3(providerClient, endpointOps)
+	if err != nil {
+		volumeClient = nil
+	}
+
 	return &client{
 		compute: computeClient,
 		image:   imageClient,
+		volume:  volumeClient,
 	}, nil
 }
 
@@ -312,6 +336,89 @@ func (c *client) CreateServer(ctx context.Context, spec servers.CreateOptsBuilde
 	return servers.Create(ctx, c.compute, spec, hintOpts).Extract()
 }
 
+// CreateServers sends multi-create request, spec should request reservation id instead of the server
+func (c *client) CreateServers(ctx context.Context, spec servers.CreateOptsBuilder, hintOpts servers.SchedulerHintOptsBuilder) (string, error) {
+	var res struct {
+		ReservationID string `json:"reservation_id"`
+	}
+
+	// response has no server object, only the reservation id
+	r := servers.Create(ctx, c.compute, spec, hintOpts)
+	err := r.Result.ExtractInto(&res)
+	if err != nil {
+		return "", err
+	}
+	if res.ReservationID == "" {
+		return "", fmt.Errorf("no reservation_id in the response")
+	}
+
+	return res.ReservationID, nil
+}
+
+// reservationListOpts filters servers created by one multi-create request
+type reservationListOpts string
+
+func (opts reservationListOpts) ToServerListQuery() (string, error) {
+	return "?" + url.Values{"reservation_id": {string(opts)}}.Encode(), nil
+}
+
+func (c *client) ListServersByReservation(ctx context.Context, reservationId string) ([]servers.Server, error) {
+	page, err := servers.List(c.compute, reservationListOpts(reservationId)).AllPages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("server listing error: %w", err)
+	}
+
+	return servers.ExtractServers(page)
+}
+
 func (c *client) DeleteServer(ctx context.Context, serverId string) error {
 	return servers.Delete(ctx, c.compute, serverId).ExtractErr()
 }
+
+func (c *client) GetLimits(ctx context.Context) (*limits.Absolute, error) {
+	lim, err := limits.Get(ctx, c.compute, nil).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get limits: %w", err)
+	}
+
+	return &lim.Absolute, nil
+}
+
+func (c *client) GetFlavor(ctx context.Context, flavorRef string) (*flavors.Flavor, error) {
+	flavor, err := flavors.Get(ctx, c.compute, flavorRef).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get flavor %s: %w", flavorRef, err)
+	}
+
+	return flavor, nil
+}
+
+func (c *client) GetVolumeLimits(ctx context.Context) (*volumelimits.Absolute, error) {
+	if c.volume == nil {
+		return nil, fmt.Errorf("block storage endpoint not found")
+	}
+
+	lim, err := volumelimits.Get(ctx, c.volume).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get volume limits: %w", err)
+	}
+
+	return &lim.Absolute, nil
+}
+
+func (c *client) ListServerGroups(ctx context.Context) ([]servergroups.ServerGroup, error) {
+	page, err := servergroups.List(c.compute, nil).AllPages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("server group listing error: %w", err)
+	}
+
+	return servergroups.ExtractServerGroups(page)
+}
+
+func (c *client) CreateServerGroup(ctx context.Context, opts servergroups.CreateOptsBuilder) (*servergroups.ServerGroup, error) {
+	return servergroups.Create(ctx, c.compute, opts).Extract()
+}
+
+func (c *client) DeleteServerGroup(ctx context.Context, groupId string) error {
+	return servergroups.Delete(ctx, c.compute, groupId).ExtractErr()
+}
@@ -85,3 +85,20 @@ func TestGetImageByName_Many(t *testing.T) {
 	_, _, err = client.GetImageByName(ctx, "flatcar")
 	assert.ErrorIs(err, gophercloud.ErrMultipleResourcesFound{Name: "flatcar", Count: 8, ResourceType: "image"})
 }
+
+func TestLazyClient(t *testing.T) {
+	c := NewLazy(&EnvCloudConfig{
+		CloudConfig: CloudConfig{
+			ClientConfigFile: "../../testdata/nonexistent-clouds.yaml",
+			Cloud:            "missing",
+		},
+	}, nil)
+
+	// cloud is not contacted until the first request
+	lc := c.(*lazyClient)
+	assert.Nil(t, lc.client)
+
+	_, err := c.ListServers(context.Background())
+	assert.Error(t, err)
+	assert.Nil(t, lc.client, "failed connection is retried by the next request")
+}
@@ -0,0 +1,168 @@
+package fpoc
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// metric of DHCP routes of secondary NICs, as in heat/eth1-no-defroute.network
+	secondaryRouteMetric = 2000
+
+	netplanPath = "/etc/netplan/90-fleeting-secondary.yaml"
+)
+
+// NetworkConfig configures secondary NICs of instances attached to several networks
+type NetworkConfig struct {
+	Enable     bool              `json:"enable"`     // optional: generate config for secondary NICs
+	Interfaces []InterfaceConfig `json:"interfaces"` // optional: settings of secondary NICs, in order of server_spec.networks[1:]
+}
+
+// InterfaceConfig settings of a secondary NIC
+type InterfaceConfig struct {
+	Name   string        `json:"name"`   // optional: interface name, default ethN
+	Routes []StaticRoute `json:"routes"` // optional: static routes via that NIC
+}
+
+// StaticRoute route added to a secondary NIC
+type StaticRoute struct {
+	To     string `json:"to"`     // destination CIDR
+	Via    string `json:"via"`    // gateway address
+	Metric int    `json:"metric"` // optional
+}
+
+// secondaryNICs returns config of all NICs except the first one, nil if not enabled
+func (g *InstanceGroup) secondaryNICs() ([]InterfaceConfig, error) {
+	if !g.NetworkConfig.Enable {
+		if len(g.NetworkConfig.Interfaces) > 0 {
+			return nil, fmt.Errorf("interfaces set, but enable is false")
+		}
+		return nil, nil
+	}
+
+	count := len(g.ServerSpec.Networks) - 1
+	if count < 1 {
+		return nil, fmt.Errorf("enable is set, but server_spec.networks has no secondary networks")
+	}
+
+	if len(g.NetworkConfig.Interfaces) > count {
+		return nil, fmt.Errorf("%d interfaces set, but server_spec.networks has only %d secondary networks", len(g.NetworkConfig.Interfaces), count)
+	}
+
+	nics := make([]InterfaceConfig, count)
+	copy(nics, g.NetworkConfig.Interfaces)
+
+	for idx := range nics {
+		nic := &nics[idx]
+		if nic.Name == "" {
+			nic.Name = fmt.Sprintf("eth%d", idx+1)
+		}
+
+		for ridx, r := range nic.Routes {
+			_, _, err := net.ParseCIDR(r.To)
+			if err != nil {
+				return nil, fmt.Errorf("interfaces[%d].routes[%d]: invalid to: %w", idx, ridx, err)
+			}
+			if net.ParseIP(r.Via) == nil {
+				return nil, fmt.Errorf("interfaces[%d].routes[%d]: invalid via: %q", idx, ridx, r.Via)
+			}
+		}
+	}
+
+	return nics, nil
+}
+
+// networkdConfig returns systemd-networkd config of the secondary NIC: DHCP without default route
+func networkdConfig(nic InterfaceConfig) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "[Match]\nName=%s\n\n[Network]\nDHCP=ipv4\n\n", nic.Name)
+	fmt.Fprintf(&sb, "[DHCPv4]\nUseRoutes=false\nUseGateway=false\nRouteMetric=%d\n", secondaryRouteMetric)
+
+	for _, r := range nic.Routes {
+		fmt.Fprintf(&sb, "\n[Route]\nDestination=%s\nGateway=%s\n", r.To, r.Via)
+		if r.Metric != 0 {
+			fmt.Fprintf(&sb, "Metric=%d\n", r.Metric)
+		}
+	}
+
+	return sb.String()
+}
+
+// netplanConfig returns netplan config of the secondary NICs
+func netplanConfig(nics []InterfaceConfig) (string, error) {
+	ethernets := make(map[string]any, len(nics))
+	for _, nic := range nics {
+		eth := map[string]any{
+			"dhcp4": true,
+			"dhcp4-overrides": map[string]any{
+				"use-routes":   false,
+				"route-metric": secondaryRouteMetric,
+			},
+		}
+
+		var routes []any
+		for _, r := range nic.Routes {
+			route := map[string]any{"to": r.To, "via": r.Via}
+			if r.Metric != 0 {
+				route["metric"] = r.Metric
+			}
+			routes = append(routes, route)
+		}
+		if len(routes) > 0 {
+			eth["routes"] = routes
+		}
+
+		ethernets[nic.Name] = eth
+	}
+
+	buf, err := yaml.Marshal(map[string]any{
+		"network": map[string]any{
+			"version":   2,
+			"ethernets": ethernets,
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal netplan config: %w", err)
+	}
+
+	return string(buf), nil
+}
+
+// networkExtras returns networkd files (Ignition) or netplan config (Cloud-Init) of the secondary NICs
+func (g *InstanceGroup) networkExtras() (*bootExtras, error) {
+	extras := &bootExtras{}
+	if g.isWindows() {
+		return extras, nil
+	}
+
+	nics, err := g.secondaryNICs()
+	if err != nil || len(nics) == 0 {
+		return extras, err
+	}
+
+	if g.UseIgnition {
+		for _, nic := range nics {
+			extras.Files = append(extras.Files, FileSpec{
+				Path:    fmt.Sprintf("/etc/systemd/network/20-%s.network", nic.Name),
+				Mode:    0o644,
+				Content: networkdConfig(nic),
+			})
+		}
+
+		return extras, nil
+	}
+
+	content, err := netplanConfig(nics)
+	if err != nil {
+		return nil, err
+	}
+
+	extras.Files = append(extras.Files, FileSpec{Path: netplanPath, Mode: 0o600, Content: content})
+	extras.Commands = append(extras.Commands, []string{"netplan", "apply"})
+
+	return extras, nil
+}
@@ -0,0 +1,90 @@
+package fpoc
+
+import (
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecondaryNICs(t *testing.T) {
+	nets := []servers.Network{{UUID: "a"}, {UUID: "b"}, {UUID: "c"}}
+
+	testCases := []struct {
+		name     string
+		networks []servers.Network
+		cfg      NetworkConfig
+		expected []string
+		isErr    bool
+	}{
+		{"single", nets[:1], NetworkConfig{Enable: true}, nil, true},
+		{"two", nets[:2], NetworkConfig{Enable: true}, []string{"eth1"}, false},
+		{"named", nets, NetworkConfig{Enable: true, Interfaces: []InterfaceConfig{{Name: "ens4"}}}, []string{"ens4", "eth2"}, false},
+		{"not-enabled", nets, NetworkConfig{}, nil, false},
+		{"not-enabled-interfaces", nets, NetworkConfig{Interfaces: []InterfaceConfig{{}}}, nil, true},
+		{"too-many", nets[:2], NetworkConfig{Enable: true, Interfaces: []InterfaceConfig{{}, {}}}, nil, true},
+		{"bad-route", nets[:2], NetworkConfig{Enable: true, Interfaces: []InterfaceConfig{{Routes: []StaticRoute{{To: "10.0.0.1", Via: "10.1.0.1"}}}}}, nil, true},
+		{"bad-via", nets[:2], NetworkConfig{Enable: true, Interfaces: []InterfaceConfig{{Routes: []StaticRoute{{To: "10.0.0.0/8", Via: "gw"}}}}}, nil, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := &InstanceGroup{NetworkConfig: tc.cfg}
+			g.ServerSpec.Networks = tc.networks
+
+			nics, err := g.secondaryNICs()
+			if tc.isErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var names []string
+			for _, nic := range nics {
+				names = append(names, nic.Name)
+			}
+			assert.Equal(t, tc.expected, names)
+		})
+	}
+}
+
+func TestNetworkExtras(t *testing.T) {
+	nic := InterfaceConfig{Name: "eth1", Routes: []StaticRoute{{To: "10.20.0.0/16", Via: "10.0.1.1", Metric: 100}}}
+
+	t.Run("ignition", func(t *testing.T) {
+		g := &InstanceGroup{UseIgnition: true, NetworkConfig: NetworkConfig{Enable: true, Interfaces: []InterfaceConfig{nic}}}
+		g.ServerSpec.Networks = []servers.Network{{UUID: "a"}, {UUID: "b"}}
+
+		extras, err := g.networkExtras()
+		require.NoError(t, err)
+		require.Len(t, extras.Files, 1)
+		assert.Equal(t, "/etc/systemd/network/20-eth1.network", extras.Files[0].Path)
+		assert.Equal(t, "[Match]\nName=eth1\n\n[Network]\nDHCP=ipv4\n\n[DHCPv4]\nUseRoutes=false\nUseGateway=false\nRouteMetric=2000\n\n[Route]\nDestination=10.20.0.0/16\nGateway=10.0.1.1\nMetric=100\n", extras.Files[0].Content)
+		assert.Empty(t, extras.Commands)
+	})
+
+	t.Run("cloud-init", func(t *testing.T) {
+		g := &InstanceGroup{NetworkConfig: NetworkConfig{Enable: true, Interfaces: []InterfaceConfig{nic}}}
+		g.ServerSpec.Networks = []servers.Network{{UUID: "a"}, {UUID: "b"}}
+
+		var err error
+		g.genExtras, err = g.networkExtras()
+		require.NoError(t, err)
+
+		spec := &ExtCreateOpts{}
+		err = g.mergeUserData(spec, g.staticExtras())
+		require.NoError(t, err)
+
+		ccs, err := cloudConfigs(spec.UserData)
+		require.NoError(t, err)
+		require.Len(t, ccs, 1)
+
+		assert.Equal(t, []any{map[string]any{
+			"path":        netplanPath,
+			"permissions": "0600",
+			"content":     "network:\n    ethernets:\n        eth1:\n            dhcp4: true\n            dhcp4-overrides:\n                route-metric: 2000\n                use-routes: false\n            routes:\n                - metric: 100\n                  to: 10.20.0.0/16\n                  via: 10.0.1.1\n    version: 2\n",
+		}}, ccs[0]["write_files"])
+		assert.Equal(t, []any{[]any{"netplan", "apply"}}, ccs[0]["runcmd"])
+	})
+}
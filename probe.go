@@ -0,0 +1,317 @@
+package fpoc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/hashicorp/go-hclog"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	ProbeTCP = "tcp"
+	ProbeSSH = "ssh"
+)
+
+// ProbeConfig configures readiness probe made from the manager
+type ProbeConfig struct {
+	Type      string `json:"type"`     // tcp or ssh (handshake with the connector credentials)
+	Port      int    `json:"port"`     // optional: port to connect, default 22
+	IntervalS string `json:"interval"` // optional: interval between probes of the instance, default 10s
+	TimeoutS  string `json:"timeout"`  // optional: timeout of one probe, default 5s
+	Interval  time.Duration
+	Timeout   time.Duration
+}
+
+func (pc *ProbeConfig) parse() error {
+	var err error
+
+	switch pc.Type {
+	case ProbeTCP, ProbeSSH:
+	default:
+		return fmt.Errorf("unknown probe type: %s", pc.Type)
+	}
+
+	if pc.Port == 0 {
+		pc.Port = 22
+	}
+
+	pc.Interval = 10 * time.Second
+	if pc.IntervalS != "" {
+		pc.Interval, err = time.ParseDuration(pc.IntervalS)
+		if err != nil {
+			return fmt.Errorf("failed to parse interval: %w", err)
+		}
+	}
+
+	pc.Timeout = 5 * time.Second
+	if pc.TimeoutS != "" {
+		pc.Timeout, err = time.ParseDuration(pc.TimeoutS)
+		if err != nil {
+			return fmt.Errorf("failed to parse timeout: %w", err)
+		}
+	}
+
+	return nil
+}
+
+const readyCommandTimeout = time.Minute
+
+// sshDialTimeout default timeout of ssh connection and handshake
+const sshDialTimeout = 10 * time.Second
+
+var errReadyCommandFailed = errors.New("ready command failed")
+
+// probeState keeps the result of asynchronous readiness checks of one instance
+type probeState struct {
+	last     time.Time
+	running  bool
+	ok       bool
+	failures int
+}
+
+type probeTracker struct {
+	mu     sync.Mutex
+	states map[string]*probeState
+}
+
+// get returns state of the instance, caller must hold the lock
+func (t *probeTracker) get(id string) *probeState {
+	if t.states == nil {
+		t.states = make(map[string]*probeState)
+	}
+
+	st, ok := t.states[id]
+	if !ok {
+		st = new(probeState)
+		t.states[id] = st
+	}
+
+	return st
+}
+
+// prune forgets instances which no longer exist
+func (t *probeTracker) prune(instances []servers.Server) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	alive := make(map[string]struct{}, len(instances))
+	for _, srv := range instances {
+		alive[srv.ID] = struct{}{}
+	}
+
+	for id := range t.states {
+		if _, ok := alive[id]; !ok {
+			delete(t.states, id)
+		}
+	}
+}
+
+// hasReadinessChecks tells if instances should be checked from the manager
+func (g *InstanceGroup) hasReadinessChecks() bool {
+	return g.Readiness.Probe != nil || len(g.ReadyCommands) > 0
+}
+
+// checkReadiness returns ready when the probe and ready commands succeeded,
+// failed when ready commands failed too many times.
+// Otherwise it starts new checks in the background if the interval passed, so Update is not blocked.
+func (g *InstanceGroup) checkReadiness(srv *servers.Server, lg hclog.Logger) (ready bool, failed bool) {
+	interval := 10 * time.Second
+	if g.Readiness.Probe != nil {
+		interval = g.Readiness.Probe.Interval
+	}
+
+	g.probes.mu.Lock()
+	defer g.probes.mu.Unlock()
+
+	st := g.probes.get(srv.ID)
+	if st.ok {
+		return true, false
+	}
+	if g.ReadyCommandsMaxFailures > 0 && st.failures >= g.ReadyCommandsMaxFailures {
+		return false, true
+	}
+	if st.running || time.Since(st.last) < interval {
+		return false, false
+	}
+
+	ipAddr, err := g.getAddress(srv)
+	if err != nil || ipAddr == "" {
+		lg.Warn("Failed to get instance address for the readiness checks", "err", err)
+		return false, false
+	}
+
+	st.running = true
+	st.last = time.Now()
+
+	port := 22
+	if g.Readiness.Probe != nil {
+		port = g.Readiness.Probe.Port
+	}
+
+	addr := net.JoinHostPort(ipAddr, strconv.Itoa(port))
+	go func() {
+		err := g.runChecks(addr)
+
+		cmdFailed := errors.Is(err, errReadyCommandFailed)
+
+		g.probes.mu.Lock()
+		st.running = false
+		st.ok = err == nil
+		if cmdFailed {
+			st.failures++
+		}
+		failures := st.failures
+		g.probes.mu.Unlock()
+
+		if cmdFailed {
+			lg.Warn("Instance not ready", "addr", addr, "err", err, "failures", failures)
+		} else if err != nil {
+			lg.Debug("Readiness probe failed", "addr", addr, "err", err)
+		} else {
+			lg.Info("Readiness checks succeeded", "addr", addr)
+		}
+	}()
+
+	return false, false
+}
+
+func (g *InstanceGroup) runChecks(addr string) error {
+	if g.Readiness.Probe != nil {
+		err := g.runProbe(addr)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(g.ReadyCommands) > 0 {
+		return g.runReadyCommands(addr)
+	}
+
+	return nil
+}
+
+func (g *InstanceGroup) runProbe(addr string) error {
+	pc := g.Readiness.Probe
+
+	if pc.Type == ProbeSSH {
+		client, err := dialSSH(g.backgroundCtx(), addr, g.sshConfig, pc.Timeout)
+		if err != nil {
+			return err
+		}
+
+		return client.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(g.backgroundCtx(), pc.Timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// dialSSH connects to the ssh server, connection and handshake are bounded by ctx and the timeout
+func dialSSH(ctx context.Context, addr string, cfg *ssh.ClientConfig, timeout time.Duration) (*ssh.Client, error) {
+	if timeout <= 0 {
+		timeout = sshDialTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// handshake do not support contexts, so close the connection on cancel
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+
+	deadline, _ := ctx.Deadline()
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	sconn, chans, reqs, err := ssh.NewClientConn(conn, addr, cfg)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("ssh handshake failed: %w", err)
+	}
+
+	if !stop() {
+		_ = sconn.Close()
+		return nil, fmt.Errorf("ssh handshake failed: %w", ctx.Err())
+	}
+
+	// commands have their own timeouts
+	err = conn.SetDeadline(time.Time{})
+	if err != nil {
+		_ = sconn.Close()
+		return nil, err
+	}
+
+	return ssh.NewClient(sconn, chans, reqs), nil
+}
+
+// runReadyCommands executes ready_commands over ssh, all of them should exit with 0
+func (g *InstanceGroup) runReadyCommands(addr string) error {
+	ctx := g.backgroundCtx()
+
+	client, err := dialSSH(ctx, addr, g.sshConfig, g.sshConfig.Timeout)
+	if err != nil {
+		return fmt.Errorf("ssh dial failed: %w", err)
+	}
+	defer client.Close() // nolint:errcheck
+
+	for _, cmd := range g.ReadyCommands {
+		err := runSSHCommand(ctx, client, cmd, readyCommandTimeout)
+		if err != nil {
+			return fmt.Errorf("%w: %q: %w", errReadyCommandFailed, cmd, err)
+		}
+	}
+
+	return nil
+}
+
+func runSSHCommand(ctx context.Context, client *ssh.Client, cmd string, timeout time.Duration) error {
+	sess, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sess.Close() // nolint:errcheck
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// session do not support contexts, so close it on timeout
+	stop := context.AfterFunc(ctx, func() {
+		_ = sess.Close()
+	})
+	defer stop()
+
+	out, err := sess.CombinedOutput(cmd)
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("%w: %w", ctx.Err(), err)
+	} else if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
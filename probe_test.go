@@ -0,0 +1,221 @@
+package fpoc
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestProbeConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	pc := &ProbeConfig{Type: ProbeTCP}
+	assert.NoError(pc.parse())
+	assert.Equal(22, pc.Port)
+	assert.Equal(10*time.Second, pc.Interval)
+	assert.Equal(5*time.Second, pc.Timeout)
+
+	pc = &ProbeConfig{Type: ProbeSSH, Port: 2222, IntervalS: "1s", TimeoutS: "2s"}
+	assert.NoError(pc.parse())
+	assert.Equal(2222, pc.Port)
+	assert.Equal(time.Second, pc.Interval)
+	assert.Equal(2*time.Second, pc.Timeout)
+
+	pc = &ProbeConfig{Type: "http"}
+	assert.Error(pc.parse())
+}
+
+func TestCheckReadinessTCP(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close() // nolint:errcheck
+
+	g := &InstanceGroup{
+		log: hclog.NewNullLogger(),
+		Readiness: ReadinessConfig{
+			Probe: &ProbeConfig{Type: ProbeTCP, Port: lis.Addr().(*net.TCPAddr).Port, IntervalS: "10ms"},
+		},
+	}
+	require.NoError(t, g.Readiness.Probe.parse())
+
+	srv := &servers.Server{ID: "srv-1", AccessIPv4: "127.0.0.1"}
+
+	// first call only starts the probe
+	ready, failed := g.checkReadiness(srv, g.log)
+	assert.False(t, ready)
+	assert.False(t, failed)
+	assert.Eventually(t, func() bool {
+		ready, _ := g.checkReadiness(srv, g.log)
+		return ready
+	}, time.Second, 20*time.Millisecond)
+
+	g.probes.prune(nil)
+	assert.Empty(t, g.probes.states)
+
+	// closed port
+	require.NoError(t, lis.Close())
+	assert.Never(t, func() bool {
+		ready, failed := g.checkReadiness(srv, g.log)
+		return ready || failed
+	}, 200*time.Millisecond, 20*time.Millisecond)
+}
+
+// startSSHServer runs ssh server which exits with 0 for "true" command and 1 for others
+func startSSHServer(t *testing.T, clientKey ssh.PublicKey) net.Listener {
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	require.NoError(t, err)
+
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown key")
+		},
+	}
+	cfg.AddHostKey(hostKey)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = lis.Close() })
+
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+
+				for nch := range chans {
+					ch, reqs, err := nch.Accept()
+					if err != nil {
+						return
+					}
+
+					go func() {
+						defer ch.Close() // nolint:errcheck
+						for req := range reqs {
+							if req.Type != "exec" {
+								_ = req.Reply(false, nil)
+								continue
+							}
+							_ = req.Reply(true, nil)
+
+							var payload struct{ Command string }
+							_ = ssh.Unmarshal(req.Payload, &payload)
+
+							status := uint32(1)
+							if payload.Command == "true" {
+								status = 0
+							}
+							_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+							return
+						}
+					}()
+				}
+			}()
+		}
+	}()
+
+	return lis
+}
+
+func TestCheckReadinessCommands(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+
+	lis := startSSHServer(t, sshPub)
+
+	testCases := []struct {
+		name     string
+		commands []string
+		ready    bool
+		failed   bool
+	}{
+		{"success", []string{"true", "true"}, true, false},
+		{"failure", []string{"true", "false"}, false, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := &InstanceGroup{
+				log: hclog.NewNullLogger(),
+				Readiness: ReadinessConfig{
+					Probe: &ProbeConfig{Type: ProbeSSH, Port: lis.Addr().(*net.TCPAddr).Port, IntervalS: "10ms"},
+				},
+				ReadyCommands:            tc.commands,
+				ReadyCommandsMaxFailures: 2,
+				sshConfig: &ssh.ClientConfig{
+					User:            "test",
+					Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+					HostKeyCallback: ssh.InsecureIgnoreHostKey(), // nolint:gosec
+				},
+			}
+			require.NoError(t, g.Readiness.Probe.parse())
+
+			srv := &servers.Server{ID: "srv-1", AccessIPv4: "127.0.0.1"}
+
+			assert.Eventually(t, func() bool {
+				ready, failed := g.checkReadiness(srv, g.log)
+				return ready == tc.ready && failed == tc.failed
+			}, 5*time.Second, 20*time.Millisecond)
+		})
+	}
+}
+
+func TestRunReadyCommandsCancel(t *testing.T) {
+	// server accepts connections, but never starts the handshake
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = lis.Close() })
+
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { _ = conn.Close() })
+		}
+	}()
+
+	g := &InstanceGroup{
+		log:           hclog.NewNullLogger(),
+		ReadyCommands: []string{"true"},
+		sshConfig: &ssh.ClientConfig{
+			User:            "test",
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(), // nolint:gosec
+		},
+	}
+	g.bgCtx, g.bgCancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, g.bgCancel)
+
+	start := time.Now()
+	err = g.runReadyCommands(lis.Addr().String())
+	assert.ErrorContains(t, err, "ssh dial failed")
+	assert.Less(t, time.Since(start), 2*time.Second, "handshake stopped on cancel")
+}
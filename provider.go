@@ -5,13 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"path"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
 	"github.com/hashicorp/go-hclog"
-	"github.com/jinzhu/copier"
 	"github.com/sardinasystems/fleeting-plugin-openstack/internal/openstackclient"
+	"golang.org/x/crypto/ssh"
 
 	"gitlab.com/gitlab-org/fleeting/fleeting/provider"
 )
@@ -27,8 +28,38 @@ type InstanceGroup struct {
 	NovaMicroversion string        `json:"nova_microversion"` // Microversion for the Nova client
 	ServerSpec       ExtCreateOpts `json:"server_spec"`       // instance creation spec
 	UseIgnition      bool          `json:"use_ignition"`      // Configure keys via Ignition (Fedora CoreOS / Flatcar)
+	IgnitionVersion  string        `json:"ignition_version"`  // optional: Ignition spec version of the user data, default ignition_version image property or 3.4.0
 	BootTimeS        string        `json:"boot_time"`         // optional: wait some time before report machine as available
 	BootTime         time.Duration
+	Readiness        ReadinessConfig `json:"readiness"`        // optional: console detectors used to check that instance booted
+	ButaneFilesDir   string          `json:"butane_files_dir"` // optional: directory for local file references in Butane config
+	Strict           bool            `json:"strict"`           // optional: fail on user data validation warnings
+
+	UserDataTemplate bool `json:"user_data_template"` // optional: render server_spec.user_data as a template
+
+	Files        []FileSpec    `json:"files"`         // optional: files added to the boot config of all instances
+	SystemdUnits []SystemdUnit `json:"systemd_units"` // optional: systemd units added to the boot config of all instances
+
+	MaxSize            int    `json:"max_size"`             // optional: maximum number of instances, default derived from the compute limits
+	MaxParallelCreates int    `json:"max_parallel_creates"` // optional: number of instances created concurrently, default 10
+	MaxParallelDeletes int    `json:"max_parallel_deletes"` // optional: number of instances deleted concurrently, default 10
+	DeleteTimeoutS     string `json:"delete_timeout"`       // optional: retry deletion of instance which still exists after that time, default 5m
+	DeleteTimeout      time.Duration
+	BatchCreate        bool `json:"batch_create"` // optional: create instances by one multi-create request, if nothing differs between them
+
+	Backends []BackendConfig `json:"backends"` // optional: additional clouds or regions used for the instances
+
+	ServerGroups *ServerGroupConfig `json:"server_groups"` // optional: pool of server groups created by the plugin for the instances
+
+	AvailabilityZones ZoneConfig `json:"availability_zones"` // optional: spread instances across availability zones
+
+	NetworkConfig NetworkConfig `json:"network_config"` // optional: config of secondary NICs of server_spec.networks
+
+	WorkerCACertificates []string         `json:"worker_ca_certificates"` // optional: PEM files added to the trust store of the instances
+	RegistryMirrors      []RegistryMirror `json:"registry_mirrors"`       // optional: registry mirrors configured for docker, containerd and podman
+
+	ReadyCommands            []string `json:"ready_commands"`              // optional: commands executed over ssh, all should succeed before report machine as available
+	ReadyCommandsMaxFailures int      `json:"ready_commands_max_failures"` // optional: mark instance as timed out after that many failed attempts, default 5
 
 	client          openstackclient.Client
 	settings        provider.Settings
@@ -36,6 +67,24 @@ type InstanceGroup struct {
 	imgProps        atomic.Pointer[openstackclient.ImageProperties]
 	sshPubKey       string
 	instanceCounter atomic.Int32
+	instanceCount   atomic.Int32 // instances of the group, as of last Update
+	maxSize         atomic.Int32
+	detectors       []consoleDetector
+	specMu          sync.Mutex // guards user data and templates reloaded from the file
+	userDataHash    string
+	templates       *specTemplates
+	genExtras       *bootExtras // generated on Init: network, CA and registry configs
+	probes          probeTracker
+	deletions       deletionTracker
+	fallbacks       fallbackTracker
+	flavorArch      map[string]string // architecture required by the flavors of flavorRefs, empty if not set
+	backends        []*backend        // primary cloud is the first one
+	backendMu       sync.Mutex
+	sshConfig       *ssh.ClientConfig
+	callback        *callbackServer
+	lastStates      map[string]provider.State // states reported by the last Update
+	bgCtx           context.Context
+	bgCancel        context.CancelFunc
 }
 
 func (g *InstanceGroup) Init(ctx context.Context, log hclog.Logger, settings provider.Settings) (provider.ProviderInfo, error) {
@@ -55,33 +104,53 @@ func (g *InstanceGroup) Init(ctx context.Context, log hclog.Logger, settings pro
 		return provider.ProviderInfo{}, err
 	}
 
-	_, err = g.ServerSpec.ToServerCreateMap()
+	err = g.initBackends()
 	if err != nil {
-		return provider.ProviderInfo{}, fmt.Errorf("failed to check server_spec: %w", err)
+		return provider.ProviderInfo{}, err
 	}
 
-	if g.ServerSpec.ImageRef != "" {
-		imgProps, err := g.client.GetImageProperties(ctx, g.ServerSpec.ImageRef)
-		if err != nil {
-			return provider.ProviderInfo{}, err
-		}
+	g.userDataHash, err = loadUserDataFile(&g.ServerSpec)
+	if err != nil {
+		return provider.ProviderInfo{}, err
+	}
+	if g.userDataHash != "" {
+		g.log.Info("User data loaded", "path", userDataFilePath(&g.ServerSpec), "sha256", g.userDataHash)
+	}
 
-		g.imgProps.Store(imgProps)
+	err = g.transpileUserData(&g.ServerSpec)
+	if err != nil {
+		return provider.ProviderInfo{}, fmt.Errorf("failed to transpile butane user data: %w", err)
 	}
 
-	// log.With("creds", settings, "image", g.imgProps).Info("settings 1")
+	err = g.initFlavors(ctx)
+	if err != nil {
+		return provider.ProviderInfo{}, fmt.Errorf("failed to check server_spec flavors: %w", err)
+	}
+
+	_, err = g.ServerSpec.ToServerCreateMap()
+	if err != nil {
+		return provider.ProviderInfo{}, fmt.Errorf("failed to check server_spec: %w", err)
+	}
 
-	if !g.UseIgnition && !settings.UseStaticCredentials {
-		return provider.ProviderInfo{}, fmt.Errorf("only static credentials supported in Cloud-Init mode")
+	err = g.initImageProperties(ctx)
+	if err != nil {
+		return provider.ProviderInfo{}, err
 	}
 
 	if g.UseIgnition {
-		err = g.initSSHKey(ctx, log, &settings)
+		_, err = g.ignitionVersion()
 		if err != nil {
 			return provider.ProviderInfo{}, err
 		}
 	}
 
+	// log.With("creds", settings, "image", g.imgProps).Info("settings 1")
+
+	err = g.initCredentials(ctx, log, &settings)
+	if err != nil {
+		return provider.ProviderInfo{}, err
+	}
+
 	// log.With("creds", settings, "image", g.imgProps).Info("settings2")
 
 	if g.BootTimeS != "" {
@@ -91,14 +160,139 @@ func (g *InstanceGroup) Init(ctx context.Context, log hclog.Logger, settings pro
 		}
 	}
 
+	g.detectors, err = compileDetectors(g.Readiness, g.UseIgnition)
+	if err != nil {
+		return provider.ProviderInfo{}, err
+	}
+
+	var sample *ExtCreateOpts
+	g.templates, sample, err = g.checkTemplates(&g.ServerSpec)
+	if err != nil {
+		return provider.ProviderInfo{}, fmt.Errorf("failed to check server_spec templates: %w", err)
+	}
+
+	err = g.checkBackendTemplates()
+	if err != nil {
+		return provider.ProviderInfo{}, fmt.Errorf("failed to check server_spec templates: %w", err)
+	}
+
+	err = g.checkUserData(sample.UserData)
+	if err != nil {
+		return provider.ProviderInfo{}, fmt.Errorf("failed to validate user data: %w", err)
+	}
+
+	err = g.initExtras(sample.UserData)
+	if err != nil {
+		return provider.ProviderInfo{}, fmt.Errorf("failed to check files and systemd_units: %w", err)
+	}
+
+	// systemd units of the token and callback can't be injected into cloudbase-init user data
+	if g.isWindows() && !g.UseIgnition && (g.Readiness.Token != nil || g.Readiness.Callback != nil) {
+		return provider.ProviderInfo{}, fmt.Errorf("readiness.token and readiness.callback are not supported for Windows images")
+	}
+
+	if g.Readiness.Callback != nil {
+		g.callback, err = newCallbackServer(g.Readiness.Callback, g.log)
+		if err != nil {
+			return provider.ProviderInfo{}, fmt.Errorf("readiness.callback: %w", err)
+		}
+	}
+
+	if g.Readiness.Probe != nil {
+		err = g.Readiness.Probe.parse()
+		if err != nil {
+			return provider.ProviderInfo{}, fmt.Errorf("readiness.probe: %w", err)
+		}
+	}
+
+	if g.Readiness.DisableConsole && !g.hasReadinessChecks() && g.Readiness.Callback == nil {
+		return provider.ProviderInfo{}, fmt.Errorf("readiness.disable_console requires readiness.probe, readiness.callback or ready_commands")
+	}
+
+	if g.ServerGroups != nil {
+		err = g.initServerGroupPools()
+		if err != nil {
+			return provider.ProviderInfo{}, fmt.Errorf("server_groups: %w", err)
+		}
+	}
+
+	err = g.initZones()
+	if err != nil {
+		return provider.ProviderInfo{}, err
+	}
+
+	if (g.Readiness.Probe != nil && g.Readiness.Probe.Type == ProbeSSH) || len(g.ReadyCommands) > 0 {
+		timeout := 10 * time.Second
+		if g.Readiness.Probe != nil {
+			timeout = g.Readiness.Probe.Timeout
+		}
+
+		g.sshConfig, err = newSSHClientConfig(&settings, timeout)
+		if err != nil {
+			return provider.ProviderInfo{}, fmt.Errorf("failed to prepare readiness ssh config: %w", err)
+		}
+	}
+
+	if g.ReadyCommandsMaxFailures == 0 {
+		g.ReadyCommandsMaxFailures = 5
+	}
+
+	if g.MaxParallelCreates <= 0 {
+		g.MaxParallelCreates = 10
+	}
+	if g.MaxParallelDeletes <= 0 {
+		g.MaxParallelDeletes = 10
+	}
+
+	if g.BatchCreate {
+		if blockers := g.batchBlockers(); len(blockers) > 0 {
+			g.log.Warn("batch_create enabled, but instances are created one by one", "reasons", blockers)
+		}
+	}
+
+	g.DeleteTimeout = defaultDeleteTimeout
+	if g.DeleteTimeoutS != "" {
+		g.DeleteTimeout, err = time.ParseDuration(g.DeleteTimeoutS)
+		if err != nil {
+			return provider.ProviderInfo{}, fmt.Errorf("failed to parse delete_timeout: %w", err)
+		}
+	}
+
+	g.bgCtx, g.bgCancel = context.WithCancel(context.Background())
+
 	g.settings = settings
-	if _, err := g.getInstances(ctx); err != nil {
+
+	err = g.checkUserDataSize(sample)
+	if err != nil {
+		return provider.ProviderInfo{}, fmt.Errorf("failed to check user data: %w", err)
+	}
+
+	instances, _, err := g.getInstances(ctx)
+	if instances == nil && err != nil {
 		return provider.ProviderInfo{}, err
+	} else if err != nil {
+		// other backends keep the group working
+		g.log.Warn("Failed to list instances of some backends", "err", err)
+	}
+	g.instanceCount.Store(int32(len(instances)))
+	g.observeZones(instances)
+
+	maxSize := g.initMaxSize(ctx)
+
+	if g.ServerGroups != nil {
+		g.initServerGroups(ctx)
+	}
+
+	if g.callback != nil {
+		err = g.callback.start()
+		if err != nil {
+			return provider.ProviderInfo{}, fmt.Errorf("readiness.callback: %w", err)
+		}
 	}
 
 	return provider.ProviderInfo{
 		ID:        path.Join("openstack", g.Cloud, g.Name),
-		MaxSize:   1000,
+		MaxSize:   maxSize,
 		Version:   Version,
 		BuildInfo: BuildInfo(),
 	}, nil
@@ -106,16 +300,35 @@ func (g *InstanceGroup) Init(ctx context.Context, log hclog.Logger, settings pro
 
 func (g *InstanceGroup) Update(ctx context.Context, update func(instance string, state provider.State)) error {
 
-	instances, err := g.getInstances(ctx)
-	if err != nil {
-		return err
+	instances, stale, reterr := g.getInstances(ctx)
+	if instances == nil && reterr != nil {
+		return reterr
 	}
 
-	var reterr error
+	states := make(map[string]provider.State, len(instances))
+	defer func() { g.lastStates = states }()
+
 	for _, srv := range instances {
 		state := provider.StateCreating
 		lg := g.log.With("server_id", srv.ID, "created", srv.Created, "status", srv.Status)
 
+		// backend failed to list, keep the last reported state
+		if _, ok := stale[srv.ID]; ok {
+			if last, ok := g.lastStates[srv.ID]; ok {
+				state = last
+			}
+
+			states[srv.ID] = state
+			update(srv.ID, state)
+			continue
+		}
+
+		if g.fallbacks.isReplaced(srv.ID) {
+			states[srv.ID] = provider.StateDeleting
+			update(srv.ID, provider.StateDeleting)
+			continue
+		}
+
 		switch srv.Status {
 		case "BUILD", "MIGRATING", "PAUSED", "REBUILD":
 			// pass
@@ -124,6 +337,11 @@ func (g *InstanceGroup) Update(ctx context.Context, update func(instance string,
 			state = provider.StateDeleting
 
 		case "ERROR":
+			if g.fallbackFlavor(ctx, &srv) {
+				state = provider.StateDeleting
+				break
+			}
+
 			// unsure if that's proper way...
 			lg.Warn("Instance is in ERROR state. Marking as a timeout.")
 			state = provider.StateTimeout
@@ -132,44 +350,159 @@ func (g *InstanceGroup) Update(ctx context.Context, update func(instance string,
 			if srv.Created.Add(g.BootTime).Before(time.Now()) {
 				// treat all nodes running long enough as Running
 				state = provider.StateRunning
+			} else if g.callback != nil {
+				if g.callback.isReady(srv.ID) {
+					state = provider.StateRunning
+				} else {
+					lg.Debug("Instance boot time not passed and no readiness callback received", "boot_time", g.BootTime)
+				}
+			} else if g.Readiness.DisableConsole {
+				state = provider.StateRunning
+			} else if detector := g.instanceDetector(&srv); detector == nil {
+				lg.Debug("Instance boot time not passed and no readiness detector for the image", "boot_time", g.BootTime)
 			} else {
-				log, err := g.client.ShowServerConsoleOutput(ctx, srv.ID)
+				log, err := g.consoleOutput(ctx, srv.ID)
 				if err != nil {
 					reterr = errors.Join(reterr, err)
 					continue
 				}
 
-				if !g.UseIgnition && IsCloudInitFinished(log) {
-					lg.Info("Instance cloud-init finished")
-					state = provider.StateRunning
-				} else if g.UseIgnition && IsIgnitionFinished(log) {
-					lg.Info("Instance ignition finished")
+				if detector.finished(log) {
+					lg.Info("Instance boot finished", "detector", detector.name)
 					state = provider.StateRunning
 				} else {
-					lg.Debug("Instance boot time not passed and cloud-init/ignition not finished", "boot_time", g.BootTime)
+					lg.Debug("Instance boot time not passed and boot not finished", "boot_time", g.BootTime, "detector", detector.name)
 				}
 			}
 		}
 
+		if state == provider.StateRunning && g.hasReadinessChecks() {
+			ready, failed := g.checkReadiness(&srv, lg)
+			if failed {
+				lg.Warn("Instance ready commands failed too many times. Marking as a timeout.")
+				state = provider.StateTimeout
+			} else if !ready {
+				lg.Debug("Instance readiness checks not succeeded yet")
+				state = provider.StateCreating
+			}
+		}
+
+		states[srv.ID] = state
 		update(srv.ID, state)
 	}
 
+	g.instanceCount.Store(int32(len(instances)))
+	g.probes.prune(instances)
+	g.fallbacks.prune(instances)
+	g.observeZones(instances)
+	if g.callback != nil {
+		g.callback.prune(instances)
+	}
+
 	return reterr
 }
 
 func (g *InstanceGroup) Increase(ctx context.Context, delta int) (succeeded int, err error) {
-	for idx := 0; idx < delta; idx++ {
-		id, err2 := g.createInstance(ctx)
+	requested := delta
+	delta, maxSizeErr := g.capDelta(delta)
+
+	// replacements of instances failed to schedule go first, with their next flavor
+	replacements := g.fallbacks.take(delta)
+	batch := g.BatchCreate && delta > 1 && len(replacements) == 0 && len(g.batchBlockers()) == 0
+
+	// select backends, each checks its quota for its share of the request
+	plan := make([]plannedInstance, 0, delta)
+	for _, r := range replacements {
+		plan = append(plan, plannedInstance{backend: r.backend, flavorRef: r.flavorRef})
+	}
+	for idx := len(plan); idx < delta; idx++ {
+		if batch && idx > 0 {
+			plan = append(plan, plan[0])
+		} else {
+			plan = append(plan, plannedInstance{backend: g.pickBackend()})
+		}
+	}
+
+	// replacements which do not fit into the quota are retried by the next Increase
+	plan, dropped, err := g.capPlanQuota(ctx, plan)
+	g.fallbacks.requeue(dropped)
+	err = errors.Join(maxSizeErr, err)
+
+	// resolve image once per backend for all instances of the request,
+	// instances of the backend failed to resolve it are not created
+	imageRefs := make(map[string]string) // backend name -> image
+	failed := make(map[string]struct{})
+	kept := plan[:0]
+	for _, p := range plan {
+		b := p.backend
+		if _, ok := failed[b.name]; ok {
+			g.fallbacks.requeue([]plannedInstance{p})
+			continue
+		}
+
+		if _, ok := imageRefs[b.name]; !ok {
+			imageRef, err2 := g.resolveImage(ctx, b)
+			if err2 != nil {
+				g.log.Error("Failed to resolve image", "err", err2, "backend", b.name)
+				g.backendFailed(b, err2)
+				if b.name != "" {
+					err2 = fmt.Errorf("backend %s: %w", b.name, err2)
+				}
+				err = errors.Join(err, err2)
+				failed[b.name] = struct{}{}
+				g.fallbacks.requeue([]plannedInstance{p})
+				continue
+			}
+			imageRefs[b.name] = imageRef
+		}
+
+		kept = append(kept, p)
+	}
+	plan = kept
+	delta = len(plan)
+
+	// quota may leave room for one instance or none
+	batch = batch && delta > 1
+
+	if batch {
+		b := plan[0].backend
+		ids, err2 := g.createBatch(ctx, b, imageRefs[b.name], delta)
+		if err2 != nil {
+			g.log.Error("Failed to create instances", "err", err2, "created", len(ids))
+			err = errors.Join(err, err2)
+		}
+
+		for _, id := range ids {
+			g.log.Info("Instance creation request successful", "id", id)
+		}
+		g.instanceCount.Add(int32(len(ids)))
+		succeeded = len(ids)
+
+		g.log.Info("Increase", "delta", requested, "succeeded", succeeded, "batch", true)
+
+		return
+	}
+
+	var mu sync.Mutex
+	forEachParallel(delta, g.MaxParallelCreates, func(idx int) {
+		p := plan[idx]
+		id, err2 := g.createInstance(ctx, p.backend, imageRefs[p.backend.name], p.flavorRef)
+
+		mu.Lock()
+		defer mu.Unlock()
+
 		if err2 != nil {
-			g.log.Error("Failed to create instance", "err", err)
+			g.log.Error("Failed to create instance", "err", err2)
 			err = errors.Join(err, err2)
 		} else {
 			g.log.Info("Instance creation request successful", "id", id)
+			g.instanceCount.Add(1)
 			succeeded++
 		}
-	}
+	})
 
-	g.log.Info("Increase", "delta", delta, "succeeded", succeeded)
+	g.log.Info("Increase", "delta", requested, "succeeded", succeeded)
+	g.logZones()
 
 	return
 }
@@ -179,91 +512,174 @@ func (g *InstanceGroup) Decrease(ctx context.Context, instances []string) (succe
 		return nil, nil
 	}
 
-	succeeded = make([]string, 0, len(instances))
-	for _, id := range instances {
-		err2 := g.client.DeleteServer(ctx, id)
-		if err2 != nil {
-			g.log.Error("Failed to delete instance", "err", err2, "id", id)
-			err = errors.Join(err, err2)
-		} else {
-			g.log.Info("Instance deletion request successful", "id", id)
-			succeeded = append(succeeded, id)
-		}
-	}
+	succeeded, errs := g.deleteInstances(ctx, instances)
+	err = errors.Join(errs...)
 
-	g.log.Info("Decrease", "instances", instances)
+	g.log.Info("Decrease", "instances", instances, "succeeded", len(succeeded))
 
 	return
 }
 
-func (g *InstanceGroup) getInstances(ctx context.Context) ([]servers.Server, error) {
-	allServers, err := g.client.ListServers(ctx)
-	if err != nil {
-		return nil, err
+// resolveImage returns imageRef of the image_name in the backend, empty if the name is not set
+func (g *InstanceGroup) resolveImage(ctx context.Context, b *backend) (string, error) {
+	imageName := g.ServerSpec.ImageName
+	if b.spec != nil && b.spec.ImageName != "" {
+		imageName = b.spec.ImageName
+	} else if b.spec != nil && b.spec.ImageRef != "" {
+		return "", nil
 	}
 
-	filteredServers := make([]servers.Server, 0, len(allServers))
-	for _, srv := range allServers {
-		cluster, ok := srv.Metadata[MetadataKey]
-		if !ok || cluster != g.Name {
-			continue
-		}
+	if imageName == "" {
+		return "", nil
+	}
 
-		filteredServers = append(filteredServers, srv)
+	imageRef, imgProps, err := b.client.GetImageByName(ctx, imageName)
+	if err != nil {
+		return "", err
 	}
 
-	return filteredServers, nil
+	g.imgProps.Store(imgProps)
+	g.log.Debug("Image resolved by name", "image_name", imageName, "image_ref", imageRef, "backend", b.name)
+
+	return imageRef, nil
 }
 
-func (g *InstanceGroup) createInstance(ctx context.Context) (string, error) {
-	spec := new(ExtCreateOpts)
-	err := copier.Copy(spec, &g.ServerSpec)
+// createInstance creates a server in the backend, imageRef and flavorRef override the ones of the spec if set
+func (g *InstanceGroup) createInstance(ctx context.Context, b *backend, imageRef, flavorRef string) (string, error) {
+	spec, templates, err := g.backendSpec(ctx, b)
 	if err != nil {
 		return "", err
 	}
 
 	index := int(g.instanceCounter.Add(1))
 
-	spec.Name = fmt.Sprintf(g.ServerSpec.Name, index)
+	if imageRef != "" {
+		spec.ImageRef = imageRef
+	}
+	if flavorRef != "" {
+		spec.FlavorRef = flavorRef
+	}
+	if b.zones != nil {
+		spec.AvailabilityZone = b.zones.pick()
+	}
+
 	if spec.Metadata == nil {
 		spec.Metadata = make(map[string]string)
 	}
+
+	data, err := g.newTemplateData(index, spec.ImageRef, spec.FlavorRef)
+	if err != nil {
+		return "", err
+	}
+
+	err = templates.render(spec, data)
+	if err != nil {
+		return "", err
+	}
+
 	spec.Metadata[MetadataKey] = g.Name
+	if len(spec.FlavorRefs) > 0 {
+		spec.Metadata[FlavorMetadataKey] = spec.FlavorRef
+	}
+	if b.zones != nil {
+		spec.Metadata[ZoneMetadataKey] = spec.AvailabilityZone
+	}
 
 	var hintOpts servers.SchedulerHintOptsBuilder
 	if spec.SchedulerHints != nil {
 		hintOpts = spec.SchedulerHints
 	}
 
-	if g.UseIgnition {
-		err := InsertSSHKeyIgn(spec, g.settings.Username, g.sshPubKey)
+	extras := g.staticExtras()
+	if g.Readiness.Token != nil {
+		token, err := newReadyToken()
 		if err != nil {
 			return "", err
 		}
+
+		spec.Metadata[ReadyTokenMetadataKey] = token
+		extras.Units = append(extras.Units, readyTokenUnit(g.Readiness.Token.After, token))
 	}
 
-	if spec.ImageName != "" {
-		imageRef, imgProps, err := g.client.GetImageByName(ctx, spec.ImageName)
+	var callbackID string
+	if g.callback != nil {
+		var unit SystemdUnit
+
+		callbackID, unit, err = g.callback.register()
 		if err != nil {
 			return "", err
 		}
 
-		spec.ImageRef = imageRef
-		g.imgProps.Store(imgProps)
+		extras.Units = append(extras.Units, unit)
+	}
 
-		g.log.Debug("Image resolved by name", "image_name", spec.ImageName, "image_ref", spec.ImageRef)
+	err = g.mergeUserData(spec, extras)
+	if err == nil {
+		spec.UserData, err = g.fitUserData(spec.UserData)
+	}
+	if err != nil {
+		if g.callback != nil {
+			g.callback.cancel(callbackID)
+		}
+		return "", err
 	}
 
-	srv, err := g.client.CreateServer(ctx, spec, hintOpts)
+	var groupID string
+	if b.groups != nil {
+		groupID, err = b.groups.assign(ctx, b.client)
+		if err != nil {
+			if g.callback != nil {
+				g.callback.cancel(callbackID)
+			}
+			return "", err
+		}
+
+		hints := servers.SchedulerHintOpts{}
+		if spec.SchedulerHints != nil {
+			hints = *spec.SchedulerHints
+		}
+		hints.Group = groupID
+		hintOpts = &hints
+	}
+
+	srv, err := b.client.CreateServer(ctx, spec, hintOpts)
+	if b.groups != nil {
+		b.groups.done(groupID, err == nil)
+	}
 	if err != nil {
+		if g.callback != nil {
+			g.callback.cancel(callbackID)
+		}
+		g.backendFailed(b, err)
 		return "", err
 	}
+	b.succeed()
+
+	id := b.instanceID(srv.ID)
+	if g.callback != nil {
+		g.callback.bind(callbackID, id)
+	}
 
-	return srv.ID, nil
+	return id, nil
+}
+
+// consoleOutput returns console output of the instance from its backend
+func (g *InstanceGroup) consoleOutput(ctx context.Context, instanceID string) (string, error) {
+	b, serverID, err := g.route(instanceID)
+	if err != nil {
+		return "", err
+	}
+
+	return b.client.ShowServerConsoleOutput(ctx, serverID)
 }
 
 func (g *InstanceGroup) ConnectInfo(ctx context.Context, instanceID string) (provider.ConnectInfo, error) {
-	srv, err := g.client.GetServer(ctx, instanceID)
+	b, serverID, err := g.route(instanceID)
+	if err != nil {
+		return provider.ConnectInfo{}, err
+	}
+
+	srv, err := b.client.GetServer(ctx, serverID)
 	if err != nil {
 		return provider.ConnectInfo{}, fmt.Errorf("failed to get server %s: %w", instanceID, err)
 	}
@@ -273,20 +689,9 @@ func (g *InstanceGroup) ConnectInfo(ctx context.Context, instanceID string) (pro
 		return provider.ConnectInfo{}, fmt.Errorf("instance status is not active: %s", srv.Status)
 	}
 
-	ipAddr := srv.AccessIPv4
-	if ipAddr == "" {
-		netAddrs, err := extractAddresses(srv)
-		if err != nil {
-			return provider.ConnectInfo{}, err
-		}
-
-		// TODO: detect internal (tenant) and external networks
-		for net, addrs := range netAddrs {
-			for _, addr := range addrs {
-				ipAddr = addr.Address
-				g.log.Debug("Use address", "network", net, "ip_address", ipAddr)
-			}
-		}
+	ipAddr, err := g.getAddress(srv)
+	if err != nil {
+		return provider.ConnectInfo{}, err
 	}
 
 	info := provider.ConnectInfo{
@@ -330,16 +735,7 @@ func (g *InstanceGroup) ConnectInfo(ctx context.Context, instanceID string) (pro
 			info.OS = imgProps.OSType
 		}
 
-		switch imgProps.Architecture {
-		case "", "x86_64":
-			info.Arch = "amd64"
-
-		case "aarch64":
-			info.Arch = "arm64"
-
-		default:
-			g.log.Warn("Unknown image arch", "arch", imgProps.Architecture)
-		}
+		setArch(&info, imgProps.Architecture, g.log)
 
 	} else {
 		// default to linux on amd64
@@ -347,9 +743,102 @@ func (g *InstanceGroup) ConnectInfo(ctx context.Context, instanceID string) (pro
 		info.Arch = "amd64"
 	}
 
+	// flavor may require other architecture than the image one
+	if arch := g.flavorArch[srv.Metadata[FlavorMetadataKey]]; arch != "" {
+		setArch(&info, arch, g.log)
+	}
+
 	return info, nil
 }
 
+// setArch sets architecture of the connect info from the OpenStack one
+func setArch(info *provider.ConnectInfo, arch string, log hclog.Logger) {
+	switch arch {
+	case "", "x86_64":
+		info.Arch = "amd64"
+
+	case "aarch64":
+		info.Arch = "arm64"
+
+	default:
+		log.Warn("Unknown arch", "arch", arch)
+	}
+}
+
+// initImageProperties loads properties of the image_ref or image_name image of the primary cloud
+func (g *InstanceGroup) initImageProperties(ctx context.Context) error {
+	var imgProps *openstackclient.ImageProperties
+	var err error
+
+	switch {
+	case g.ServerSpec.ImageRef != "":
+		imgProps, err = g.client.GetImageProperties(ctx, g.ServerSpec.ImageRef)
+	case g.ServerSpec.ImageName != "":
+		_, imgProps, err = g.client.GetImageByName(ctx, g.ServerSpec.ImageName)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	g.imgProps.Store(imgProps)
+	return nil
+}
+
+// isWindows tells if the image is a Windows one
+func (g *InstanceGroup) isWindows() bool {
+	imgProps := g.imgProps.Load()
+	return imgProps != nil && imgProps.OSType == "windows"
+}
+
+// instanceDetector returns console detector for the instance, token detector has priority
+func (g *InstanceGroup) instanceDetector(srv *servers.Server) *consoleDetector {
+	if token, ok := srv.Metadata[ReadyTokenMetadataKey]; ok && token != "" {
+		return newTokenDetector(token)
+	}
+
+	return selectDetector(g.detectors, g.imgProps.Load())
+}
+
+// getAddress selects the address used to connect to the instance
+func (g *InstanceGroup) getAddress(srv *servers.Server) (string, error) {
+	ipAddr := srv.AccessIPv4
+	if ipAddr == "" {
+		netAddrs, err := extractAddresses(srv)
+		if err != nil {
+			return "", err
+		}
+
+		// TODO: detect internal (tenant) and external networks
+		for net, addrs := range netAddrs {
+			for _, addr := range addrs {
+				ipAddr = addr.Address
+				g.log.Debug("Use address", "network", net, "ip_address", ipAddr)
+			}
+		}
+	}
+
+	return ipAddr, nil
+}
+
+// backgroundCtx returns context for the tasks outliving the call, cancelled on Shutdown
+func (g *InstanceGroup) backgroundCtx() context.Context {
+	if g.bgCtx == nil {
+		return context.Background()
+	}
+
+	return g.bgCtx
+}
+
 func (g *InstanceGroup) Shutdown(ctx context.Context) error {
+	if g.bgCancel != nil {
+		g.bgCancel()
+	}
+
+	if g.callback != nil {
+		return g.callback.shutdown(ctx)
+	}
+
 	return nil
 }
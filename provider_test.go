@@ -0,0 +1,99 @@
+package fpoc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/limits"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/hashicorp/go-hclog"
+	"github.com/sardinasystems/fleeting-plugin-openstack/internal/openstackclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCreateClient records created servers, fails every failEvery-th request
+type fakeCreateClient struct {
+	fakeLimitsClient
+
+	failEvery   int32
+	imageErr    error
+	imageLookup atomic.Int32
+	requests    atomic.Int32
+	running     atomic.Int32
+	maxRunning  atomic.Int32
+
+	mu      sync.Mutex
+	created []*ExtCreateOpts
+}
+
+func (c *fakeCreateClient) GetImageByName(ctx context.Context, imageName string) (string, *openstackclient.ImageProperties, error) {
+	c.imageLookup.Add(1)
+	if c.imageErr != nil {
+		return "", nil, c.imageErr
+	}
+
+	return "image-" + imageName, &openstackclient.ImageProperties{}, nil
+}
+
+func (c *fakeCreateClient) CreateServer(ctx context.Context, spec servers.CreateOptsBuilder, hintOpts servers.SchedulerHintOptsBuilder) (*servers.Server, error) {
+	n := c.requests.Add(1)
+
+	running := c.running.Add(1)
+	defer c.running.Add(-1)
+	for {
+		old := c.maxRunning.Load()
+		if running <= old || c.maxRunning.CompareAndSwap(old, running) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if c.failEvery > 0 && n%c.failEvery == 0 {
+		return nil, errors.New("create failed")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.created = append(c.created, spec.(*ExtCreateOpts))
+
+	return &servers.Server{ID: fmt.Sprintf("srv-%d", n)}, nil
+}
+
+func TestIncrease(t *testing.T) {
+	client := &fakeCreateClient{
+		fakeLimitsClient: fakeLimitsClient{limits: limits.Absolute{MaxTotalInstances: -1, MaxTotalCores: -1, MaxTotalRAMSize: -1}},
+		failEvery:        5,
+	}
+
+	g := &InstanceGroup{
+		Name:               "ci",
+		MaxParallelCreates: 4,
+		log:                hclog.NewNullLogger(),
+		client:             client,
+	}
+	g.ServerSpec.Name = "runner-%d"
+	g.ServerSpec.ImageName = "flatcar"
+
+	var err error
+	g.templates, _, err = g.checkTemplates(&g.ServerSpec)
+	require.NoError(t, err)
+
+	succeeded, err := g.Increase(context.Background(), 20)
+	assert.Equal(t, 16, succeeded)
+	assert.Error(t, err)
+	assert.Len(t, client.created, 16)
+
+	assert.EqualValues(t, 1, client.imageLookup.Load())
+	assert.EqualValues(t, 4, client.maxRunning.Load())
+	assert.EqualValues(t, 16, g.instanceCount.Load())
+
+	for _, spec := range client.created {
+		assert.Equal(t, "image-flatcar", spec.ImageRef)
+	}
+}
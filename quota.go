@@ -0,0 +1,305 @@
+package fpoc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/limits"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+)
+
+const (
+	defaultMaxSize       = 1000
+	quotaRefreshInterval = 5 * time.Minute
+)
+
+// ErrQuotaExceeded returned by Increase for instances which do not fit into the project quota
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// ErrMaxSizeExceeded returned by Increase for instances above max_size
+var ErrMaxSizeExceeded = errors.New("max size exceeded")
+
+// fitCount returns how many items of the size fit into the free part of the limit, -1 if unlimited
+func fitCount(limit, used, size int) int {
+	// negative limit means unlimited
+	if limit < 0 || size <= 0 {
+		return -1
+	}
+
+	return max(limit-used, 0) / size
+}
+
+// minFit returns the smallest count and its resource, -1 if all are unlimited
+func minFit(counts map[string]int) (int, string) {
+	ret, resource := -1, ""
+	for _, name := range slices.Sorted(maps.Keys(counts)) {
+		n := counts[name]
+		if n >= 0 && (ret < 0 || n < ret) {
+			ret, resource = n, name
+		}
+	}
+
+	return ret, resource
+}
+
+// quotaMaxSize returns number of instances of the flavor which fit into the project limits
+func quotaMaxSize(lim *limits.Absolute, vcpus, ramMB int) int {
+	size, _ := minFit(map[string]int{
+		"instances": fitCount(lim.MaxTotalInstances, 0, 1),
+		"cores":     fitCount(lim.MaxTotalCores, 0, vcpus),
+		"ram":       fitCount(lim.MaxTotalRAMSize, 0, ramMB),
+	})
+
+	if size < 0 || size > defaultMaxSize {
+		return defaultMaxSize
+	}
+
+	return size
+}
+
+// volumeUsage returns number and size of volumes created for each instance booted from volume
+func volumeUsage(spec *ExtCreateOpts) (volumes, gigabytes int) {
+	for _, bd := range spec.BlockDevice {
+		if bd.DestinationType == servers.DestinationVolume && bd.SourceType != servers.SourceVolume {
+			volumes++
+			gigabytes += bd.VolumeSize
+		}
+	}
+
+	return
+}
+
+// quotaSpec returns flavor and block devices of the instances created in the backend, flavorRef overrides them if set
+func (g *InstanceGroup) quotaSpec(b *backend, flavorRef string) (string, []servers.BlockDevice) {
+	flavor, blockDevice := g.ServerSpec.FlavorRef, g.ServerSpec.BlockDevice
+	if b.spec != nil && b.spec.FlavorRef != "" {
+		flavor = b.spec.FlavorRef
+	}
+	if b.spec != nil && len(b.spec.BlockDevice) > 0 {
+		blockDevice = b.spec.BlockDevice
+	}
+	if flavorRef != "" {
+		flavor = flavorRef
+	}
+
+	return flavor, blockDevice
+}
+
+// quotaHeadroom returns number of instances which can be created within the project limits of the backend and the limiting resource, -1 if unlimited
+func (g *InstanceGroup) quotaHeadroom(ctx context.Context, b *backend, flavorRef string) (int, string, error) {
+	lim, err := b.client.GetLimits(ctx)
+	if err != nil {
+		return 0, "", err
+	}
+
+	flavorRef, blockDevice := g.quotaSpec(b, flavorRef)
+
+	var vcpus, ram int
+	if flavorRef != "" {
+		flavor, err := b.client.GetFlavor(ctx, flavorRef)
+		if err != nil {
+			return 0, "", err
+		}
+
+		vcpus, ram = flavor.VCPUs, flavor.RAM
+	}
+
+	counts := map[string]int{
+		"instances": fitCount(lim.MaxTotalInstances, lim.TotalInstancesUsed, 1),
+		"cores":     fitCount(lim.MaxTotalCores, lim.TotalCoresUsed, vcpus),
+		"ram":       fitCount(lim.MaxTotalRAMSize, lim.TotalRAMUsed, ram),
+	}
+
+	volumes, gigabytes := volumeUsage(&ExtCreateOpts{CreateOpts: servers.CreateOpts{BlockDevice: blockDevice}})
+	if volumes > 0 {
+		vlim, err := b.client.GetVolumeLimits(ctx)
+		if err != nil {
+			return 0, "", err
+		}
+
+		counts["volumes"] = fitCount(vlim.MaxTotalVolumes, vlim.TotalVolumesUsed, volumes)
+		counts["gigabytes"] = fitCount(vlim.MaxTotalVolumeGigabytes, vlim.TotalGigabytesUsed, gigabytes)
+	}
+
+	headroom, resource := minFit(counts)
+	return headroom, resource, nil
+}
+
+// capQuota limits the number of instances to create in the backend by its quota headroom, returns quota error for the rest.
+// flavorRef overrides the flavor of the spec if set.
+func (g *InstanceGroup) capQuota(ctx context.Context, b *backend, flavorRef string, delta int) (int, error) {
+	headroom, resource, err := g.quotaHeadroom(ctx, b, flavorRef)
+	if err != nil {
+		// do not block scaling if limits can't be read, Nova checks quota anyway
+		g.log.Warn("Failed to check quota headroom", "err", err, "backend", b.name)
+		return delta, nil
+	}
+
+	if headroom < 0 || headroom >= delta {
+		return delta, nil
+	}
+
+	g.log.Warn("Increase capped by quota", "delta", delta, "headroom", headroom, "resource", resource, "backend", b.name)
+
+	err = fmt.Errorf("%w: %d of %d instances not created, %s quota allows %d more", ErrQuotaExceeded, delta-headroom, delta, resource, headroom)
+	if b.name != "" {
+		err = fmt.Errorf("backend %s: %w", b.name, err)
+	}
+
+	return headroom, err
+}
+
+// capPlanQuota removes instances which do not fit into the quota of their backend and flavor from the plan,
+// returns the removed ones too
+func (g *InstanceGroup) capPlanQuota(ctx context.Context, plan []plannedInstance) (kept, dropped []plannedInstance, err error) {
+	type shareKey struct {
+		backend   string
+		flavorRef string
+	}
+
+	var errs []error
+	var order []shareKey
+	shares := make(map[shareKey]int)
+	backends := make(map[string]*backend)
+	for _, p := range plan {
+		key := shareKey{p.backend.name, p.flavorRef}
+		if _, ok := shares[key]; !ok {
+			order = append(order, key)
+			backends[key.backend] = p.backend
+		}
+		shares[key]++
+	}
+
+	allowed := make(map[shareKey]int, len(shares))
+	for _, key := range order {
+		n, err := g.capQuota(ctx, backends[key.backend], key.flavorRef, shares[key])
+		if err != nil {
+			errs = append(errs, err)
+		}
+		allowed[key] = n
+	}
+
+	for _, p := range plan {
+		key := shareKey{p.backend.name, p.flavorRef}
+		if allowed[key] > 0 {
+			allowed[key]--
+			kept = append(kept, p)
+		} else {
+			dropped = append(dropped, p)
+		}
+	}
+
+	return kept, dropped, errors.Join(errs...)
+}
+
+// backendMaxSize derives number of instances which fit into the compute limits of the backend
+func (g *InstanceGroup) backendMaxSize(ctx context.Context, b *backend) (int, *limits.Absolute, error) {
+	lim, err := b.client.GetLimits(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	flavorRef, _ := g.quotaSpec(b, "")
+
+	var vcpus, ram int
+	if flavorRef != "" {
+		flavor, err := b.client.GetFlavor(ctx, flavorRef)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		vcpus, ram = flavor.VCPUs, flavor.RAM
+	}
+
+	return quotaMaxSize(lim, vcpus, ram), lim, nil
+}
+
+// refreshMaxSize derives max size from the compute limits of all backends, backends failed to read limits are skipped
+func (g *InstanceGroup) refreshMaxSize(ctx context.Context) error {
+	var size int
+	var errs []error
+
+	backends := g.getBackends()
+	for _, b := range backends {
+		n, lim, err := g.backendMaxSize(ctx, b)
+		if err != nil {
+			if b.name != "" {
+				err = fmt.Errorf("backend %s: %w", b.name, err)
+			}
+			errs = append(errs, err)
+			continue
+		}
+
+		g.log.Debug("Compute limits", "backend", b.name, "max_size", n,
+			"max_instances", lim.MaxTotalInstances, "max_cores", lim.MaxTotalCores, "max_ram", lim.MaxTotalRAMSize)
+		size += n
+	}
+
+	if len(errs) == len(backends) {
+		return errors.Join(errs...)
+	} else if len(errs) > 0 {
+		g.log.Warn("Failed to read compute limits of some backends", "err", errors.Join(errs...))
+	}
+
+	size = min(size, defaultMaxSize)
+	if old := int(g.maxSize.Swap(int32(size))); old != size {
+		g.log.Info("Max size derived from compute limits", "max_size", size, "old_max_size", old)
+	}
+
+	return nil
+}
+
+// initMaxSize sets max size from the option or the compute limits, which are refreshed in the background
+func (g *InstanceGroup) initMaxSize(ctx context.Context) int {
+	if g.MaxSize > 0 {
+		g.maxSize.Store(int32(g.MaxSize))
+		return g.MaxSize
+	}
+
+	err := g.refreshMaxSize(ctx)
+	if err != nil {
+		g.log.Warn("Failed to derive max size from compute limits, using default", "err", err, "max_size", defaultMaxSize)
+		g.maxSize.Store(defaultMaxSize)
+	}
+
+	go func() {
+		ticker := time.NewTicker(quotaRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-g.bgCtx.Done():
+				return
+			case <-ticker.C:
+				err := g.refreshMaxSize(g.bgCtx)
+				if err != nil {
+					g.log.Warn("Failed to refresh compute limits", "err", err)
+				}
+			}
+		}
+	}()
+
+	return int(g.maxSize.Load())
+}
+
+// capDelta limits the number of instances to create by the max size, returns max size error for the rest
+func (g *InstanceGroup) capDelta(delta int) (int, error) {
+	maxSize := int(g.maxSize.Load())
+	if maxSize <= 0 {
+		return delta, nil
+	}
+
+	count := int(g.instanceCount.Load())
+	if count+delta <= maxSize {
+		return delta, nil
+	}
+
+	capped := max(maxSize-count, 0)
+	g.log.Warn("Increase capped by max size", "delta", delta, "capped_delta", capped, "instances", count, "max_size", maxSize)
+
+	return capped, fmt.Errorf("%w: %d of %d instances not created, %d instances of max size %d exist", ErrMaxSizeExceeded, delta-capped, delta, count, maxSize)
+}
@@ -0,0 +1,133 @@
+package fpoc
+
+import (
+	"context"
+	"testing"
+
+	volumelimits "github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/limits"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/flavors"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/limits"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/hashicorp/go-hclog"
+	"github.com/sardinasystems/fleeting-plugin-openstack/internal/openstackclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuotaMaxSize(t *testing.T) {
+	testCases := []struct {
+		name     string
+		lim      limits.Absolute
+		vcpus    int
+		ram      int
+		expected int
+	}{
+		{"unlimited", limits.Absolute{MaxTotalInstances: -1, MaxTotalCores: -1, MaxTotalRAMSize: -1}, 4, 8192, defaultMaxSize},
+		{"instances", limits.Absolute{MaxTotalInstances: 10, MaxTotalCores: -1, MaxTotalRAMSize: -1}, 4, 8192, 10},
+		{"cores", limits.Absolute{MaxTotalInstances: 10, MaxTotalCores: 20, MaxTotalRAMSize: -1}, 4, 8192, 5},
+		{"ram", limits.Absolute{MaxTotalInstances: 10, MaxTotalCores: 20, MaxTotalRAMSize: 20480}, 4, 8192, 2},
+		{"no-flavor", limits.Absolute{MaxTotalInstances: 10, MaxTotalCores: 20, MaxTotalRAMSize: 20480}, 0, 0, 10},
+		{"zero", limits.Absolute{MaxTotalInstances: 0, MaxTotalCores: 20, MaxTotalRAMSize: 20480}, 4, 8192, 0},
+		{"huge", limits.Absolute{MaxTotalInstances: 100000, MaxTotalCores: -1, MaxTotalRAMSize: -1}, 1, 512, defaultMaxSize},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, quotaMaxSize(&tc.lim, tc.vcpus, tc.ram))
+		})
+	}
+}
+
+func TestCapDelta(t *testing.T) {
+	g := &InstanceGroup{log: hclog.NewNullLogger()}
+
+	delta, err := g.capDelta(5)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, delta, "max size not set")
+
+	g.maxSize.Store(10)
+	g.instanceCount.Store(3)
+	delta, err = g.capDelta(5)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, delta)
+
+	delta, err = g.capDelta(8)
+	assert.ErrorIs(t, err, ErrMaxSizeExceeded)
+	assert.EqualError(t, err, "max size exceeded: 1 of 8 instances not created, 3 instances of max size 10 exist")
+	assert.Equal(t, 7, delta)
+
+	g.instanceCount.Store(12)
+	delta, err = g.capDelta(1)
+	assert.ErrorIs(t, err, ErrMaxSizeExceeded)
+	assert.Equal(t, 0, delta)
+}
+
+// fakeLimitsClient returns static limits, other client methods are not implemented
+type fakeLimitsClient struct {
+	openstackclient.Client
+
+	limits       limits.Absolute
+	volumeLimits volumelimits.Absolute
+	flavor       flavors.Flavor
+}
+
+func (c *fakeLimitsClient) GetLimits(ctx context.Context) (*limits.Absolute, error) {
+	return &c.limits, nil
+}
+
+func (c *fakeLimitsClient) GetFlavor(ctx context.Context, flavorRef string) (*flavors.Flavor, error) {
+	return &c.flavor, nil
+}
+
+func (c *fakeLimitsClient) GetVolumeLimits(ctx context.Context) (*volumelimits.Absolute, error) {
+	return &c.volumeLimits, nil
+}
+
+func TestCapQuota(t *testing.T) {
+	unlimited := limits.Absolute{MaxTotalInstances: -1, MaxTotalCores: -1, MaxTotalRAMSize: -1}
+	bootFromVolume := []servers.BlockDevice{
+		{SourceType: servers.SourceImage, DestinationType: servers.DestinationVolume, VolumeSize: 20, BootIndex: 0},
+		{SourceType: servers.SourceVolume, DestinationType: servers.DestinationVolume, UUID: "shared"},
+	}
+
+	testCases := []struct {
+		name         string
+		limits       limits.Absolute
+		volumeLimits volumelimits.Absolute
+		blockDevice  []servers.BlockDevice
+		delta        int
+		expected     int
+		expErr       string
+	}{
+		{"unlimited", unlimited, volumelimits.Absolute{}, nil, 5, 5, ""},
+		{"instances", limits.Absolute{MaxTotalInstances: 10, TotalInstancesUsed: 7, MaxTotalCores: -1, MaxTotalRAMSize: -1}, volumelimits.Absolute{}, nil, 5, 3, "quota exceeded: 2 of 5 instances not created, instances quota allows 3 more"},
+		{"cores", limits.Absolute{MaxTotalInstances: 10, MaxTotalCores: 20, TotalCoresUsed: 12, MaxTotalRAMSize: -1}, volumelimits.Absolute{}, nil, 5, 2, "cores quota allows 2 more"},
+		{"ram-exhausted", limits.Absolute{MaxTotalInstances: -1, MaxTotalCores: -1, MaxTotalRAMSize: 8192, TotalRAMUsed: 8192}, volumelimits.Absolute{}, nil, 2, 0, "ram quota allows 0 more"},
+		{"volumes-ignored", unlimited, volumelimits.Absolute{MaxTotalVolumes: 0}, nil, 2, 2, ""},
+		{"gigabytes", unlimited, volumelimits.Absolute{MaxTotalVolumes: -1, MaxTotalVolumeGigabytes: 100, TotalGigabytesUsed: 50}, bootFromVolume, 5, 2, "gigabytes quota allows 2 more"},
+		{"volumes", unlimited, volumelimits.Absolute{MaxTotalVolumes: 10, TotalVolumesUsed: 9, MaxTotalVolumeGigabytes: -1}, bootFromVolume, 5, 1, "volumes quota allows 1 more"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := &InstanceGroup{
+				log: hclog.NewNullLogger(),
+				client: &fakeLimitsClient{
+					limits:       tc.limits,
+					volumeLimits: tc.volumeLimits,
+					flavor:       flavors.Flavor{VCPUs: 4, RAM: 4096},
+				},
+			}
+			g.ServerSpec.FlavorRef = "m1.large"
+			g.ServerSpec.BlockDevice = tc.blockDevice
+
+			delta, err := g.capQuota(context.Background(), g.getBackends()[0], "", tc.delta)
+			assert.Equal(t, tc.expected, delta)
+			if tc.expErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrQuotaExceeded)
+			assert.ErrorContains(t, err, tc.expErr)
+		})
+	}
+}
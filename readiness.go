@@ -0,0 +1,264 @@
+package fpoc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sardinasystems/fleeting-plugin-openstack/internal/openstackclient"
+)
+
+// DetectorFunc checks console output for a sign that the instance finished booting
+type DetectorFunc func(log string) bool
+
+var builtinDetectors = map[string]DetectorFunc{
+	"cloud-init":     IsCloudInitFinished,
+	"ignition":       IsIgnitionFinished,
+	"cloudbase-init": IsCloudbaseInitFinished,
+}
+
+// ReadinessConfig configures how the plugin decides that the instance has booted
+type ReadinessConfig struct {
+	// Ordered list of console detectors, first one matching image properties is used.
+	// If empty: cloudbase-init for windows images, otherwise ignition or cloud-init depending on use_ignition.
+	Detectors []DetectorConfig `json:"detectors,omitempty"`
+
+	// Do not read console output, e.g. if os-getConsoleOutput forbidden by the policy
+	DisableConsole bool `json:"disable_console,omitempty"`
+
+	// optional: probe instance from the manager before report it as running
+	Probe *ProbeConfig `json:"probe,omitempty"`
+
+	// optional: instance prints unique token to the console, used instead of the detectors
+	Token *TokenConfig `json:"token,omitempty"`
+
+	// optional: instance reports readiness to the HTTP listener hosted by the plugin, console is not polled
+	Callback *CallbackConfig `json:"callback,omitempty"`
+}
+
+// TokenConfig configures per-instance readiness token printed to /dev/console
+type TokenConfig struct {
+	After string `json:"after"` // optional: systemd unit after which token is printed, default multi-user.target
+}
+
+// DetectorConfig describes one console output detector.
+// Exactly one of Builtin, Regex or Sequence must be set.
+type DetectorConfig struct {
+	Builtin  string   `json:"builtin,omitempty"`  // name of the built-in detector: cloud-init, ignition, cloudbase-init
+	Regex    string   `json:"regex,omitempty"`    // any console line should match
+	Sequence []string `json:"sequence,omitempty"` // console lines should match these regexes in order
+
+	// optional: use detector only for images with matching properties
+	OSType   string `json:"os_type,omitempty"`
+	OSDistro string `json:"os_distro,omitempty"`
+}
+
+type consoleDetector struct {
+	name     string
+	osType   string
+	osDistro string
+	finished DetectorFunc
+}
+
+// compileDetectors prepares detectors from the config or the default ones
+func compileDetectors(cfg ReadinessConfig, useIgnition bool) ([]consoleDetector, error) {
+	dcfgs := cfg.Detectors
+	if len(dcfgs) == 0 {
+		dflt := "cloud-init"
+		if useIgnition {
+			dflt = "ignition"
+		}
+
+		dcfgs = []DetectorConfig{
+			{Builtin: "cloudbase-init", OSType: "windows"},
+			{Builtin: dflt},
+		}
+	}
+
+	ret := make([]consoleDetector, 0, len(dcfgs))
+	for idx, dc := range dcfgs {
+		det, err := dc.compile()
+		if err != nil {
+			return nil, fmt.Errorf("readiness.detectors[%d]: %w", idx, err)
+		}
+
+		if det.name == "" {
+			det.name = fmt.Sprintf("detectors[%d]", idx)
+		}
+
+		ret = append(ret, det)
+	}
+
+	return ret, nil
+}
+
+func (dc DetectorConfig) compile() (consoleDetector, error) {
+	det := consoleDetector{
+		osType:   dc.OSType,
+		osDistro: dc.OSDistro,
+	}
+
+	set := 0
+	if dc.Builtin != "" {
+		set++
+	}
+	if dc.Regex != "" {
+		set++
+	}
+	if len(dc.Sequence) > 0 {
+		set++
+	}
+	if set != 1 {
+		return det, fmt.Errorf("exactly one of builtin, regex or sequence must be set")
+	}
+
+	switch {
+	case dc.Builtin != "":
+		fn, ok := builtinDetectors[dc.Builtin]
+		if !ok {
+			return det, fmt.Errorf("unknown builtin detector: %s", dc.Builtin)
+		}
+
+		det.name = dc.Builtin
+		det.finished = fn
+
+	case dc.Regex != "":
+		re, err := regexp.Compile(dc.Regex)
+		if err != nil {
+			return det, fmt.Errorf("failed to compile regex: %w", err)
+		}
+
+		det.finished = NewSequenceDetector(re)
+
+	default:
+		res := make([]*regexp.Regexp, 0, len(dc.Sequence))
+		for idx, expr := range dc.Sequence {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return det, fmt.Errorf("failed to compile sequence[%d]: %w", idx, err)
+			}
+
+			res = append(res, re)
+		}
+
+		det.finished = NewSequenceDetector(res...)
+	}
+
+	return det, nil
+}
+
+// matches checks that detector may be used for the image
+func (det consoleDetector) matches(imgProps *openstackclient.ImageProperties) bool {
+	if det.osType == "" && det.osDistro == "" {
+		return true
+	}
+	if imgProps == nil {
+		return false
+	}
+
+	if det.osType != "" && det.osType != imgProps.OSType {
+		return false
+	}
+	if det.osDistro != "" && det.osDistro != imgProps.OSDistro {
+		return false
+	}
+
+	return true
+}
+
+// selectDetector returns first detector applicable for the image or nil
+func selectDetector(detectors []consoleDetector, imgProps *openstackclient.ImageProperties) *consoleDetector {
+	for idx := range detectors {
+		if detectors[idx].matches(imgProps) {
+			return &detectors[idx]
+		}
+	}
+
+	return nil
+}
+
+// NewSequenceDetector returns detector which search for lines matching regexes in order
+func NewSequenceDetector(res ...*regexp.Regexp) DetectorFunc {
+	return func(log string) bool {
+		if len(res) == 0 {
+			return false
+		}
+
+		lines := strings.Split(log, "\n")
+
+		next := 0
+		for _, line := range lines {
+			if res[next].MatchString(line) {
+				next++
+				if next == len(res) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+const (
+	ReadyTokenMetadataKey = "fleeting-ready-token"
+	readyTokenUnitName    = "fleeting-ready.service"
+	readyTokenPrefix      = "fleeting-ready: "
+)
+
+func newReadyToken() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate ready token: %w", err)
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// readyTokenUnit prints the token to the console after the unit
+func readyTokenUnit(after, token string) SystemdUnit {
+	execStart := fmt.Sprintf(`/bin/sh -c 'echo "%s%s" > /dev/console'`, readyTokenPrefix, token)
+
+	return readinessUnit(readyTokenUnitName, after, execStart)
+}
+
+// readinessUnit runs the command once the after unit is started
+func readinessUnit(name, after, execStart string) SystemdUnit {
+	if after == "" {
+		after = "multi-user.target"
+	}
+
+	// DefaultDependencies=no prevents ordering cycle if after is a target
+	contents := fmt.Sprintf(`[Unit]
+Description=Report readiness to fleeting-plugin-openstack
+DefaultDependencies=no
+After=%[1]s
+
+[Service]
+Type=oneshot
+ExecStart=%[2]s
+
+[Install]
+WantedBy=%[1]s
+`, after, execStart)
+
+	return SystemdUnit{
+		Name:     name,
+		Enabled:  true,
+		Contents: contents,
+	}
+}
+
+// newTokenDetector search for the token printed by readyTokenUnit
+func newTokenDetector(token string) *consoleDetector {
+	line := readyTokenPrefix + token
+
+	return &consoleDetector{
+		name: "token",
+		finished: func(log string) bool {
+			return strings.Contains(log, line)
+		},
+	}
+}
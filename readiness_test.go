@@ -0,0 +1,115 @@
+package fpoc
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sardinasystems/fleeting-plugin-openstack/internal/openstackclient"
+)
+
+func TestCompileDetectors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		cfg      ReadinessConfig
+		ignition bool
+		imgProps *openstackclient.ImageProperties
+		expected string
+		isErr    bool
+	}{
+		{"default-cloud-init", ReadinessConfig{}, false, nil, "cloud-init", false},
+		{"default-ignition", ReadinessConfig{}, true, &openstackclient.ImageProperties{OSDistro: "flatcar"}, "ignition", false},
+		{"default-windows", ReadinessConfig{}, false, &openstackclient.ImageProperties{OSType: "windows"}, "cloudbase-init", false},
+		{"by-distro", ReadinessConfig{Detectors: []DetectorConfig{
+			{Builtin: "ignition", OSDistro: "flatcar"},
+			{Regex: `login:`},
+		}}, false, &openstackclient.ImageProperties{OSDistro: "flatcar"}, "ignition", false},
+		{"fallback", ReadinessConfig{Detectors: []DetectorConfig{
+			{Builtin: "ignition", OSDistro: "flatcar"},
+			{Regex: `login:`},
+		}}, false, &openstackclient.ImageProperties{OSDistro: "ubuntu"}, "detectors[1]", false},
+		{"no-match", ReadinessConfig{Detectors: []DetectorConfig{
+			{Builtin: "ignition", OSDistro: "flatcar"},
+		}}, false, nil, "", false},
+		{"unknown-builtin", ReadinessConfig{Detectors: []DetectorConfig{{Builtin: "foo"}}}, false, nil, "", true},
+		{"bad-regex", ReadinessConfig{Detectors: []DetectorConfig{{Regex: `(`}}}, false, nil, "", true},
+		{"ambiguous", ReadinessConfig{Detectors: []DetectorConfig{{Regex: `login:`, Builtin: "cloud-init"}}}, false, nil, "", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			detectors, err := compileDetectors(tc.cfg, tc.ignition)
+			if tc.isErr {
+				assert.Error(err)
+				return
+			}
+			require.NoError(t, err)
+
+			det := selectDetector(detectors, tc.imgProps)
+			if tc.expected == "" {
+				assert.Nil(det)
+			} else if assert.NotNil(det) {
+				assert.Equal(tc.expected, det.name)
+			}
+		})
+	}
+}
+
+func TestDetectorConfig(t *testing.T) {
+	testCases := []struct {
+		name     string
+		cfg      DetectorConfig
+		file     string
+		readLen  int
+		expected bool
+	}{
+		{"regex-not-found", DetectorConfig{Regex: `Cloud-init v\. \S+ finished`}, "testdata/console_ubuntu2204.txt", 4096, false},
+		{"regex-found", DetectorConfig{Regex: `Cloud-init v\. \S+ finished`}, "testdata/console_ubuntu2204.txt", 102400, true},
+		{"sequence-not-found", DetectorConfig{Sequence: []string{`^SSH host key: `, `login: `}}, "testdata/console_flatcar.txt", 4096, false},
+		{"sequence-found", DetectorConfig{Sequence: []string{`^SSH host key: `, `login: `}}, "testdata/console_flatcar.txt", 102400, true},
+		{"sequence-wrong-order", DetectorConfig{Sequence: []string{`login: `, `GNU GRUB`}}, "testdata/console_flatcar.txt", 102400, false},
+		{"builtin", DetectorConfig{Builtin: "cloudbase-init"}, "testdata/console_windows2022.txt", 102400, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf, err := os.ReadFile(tc.file)
+			require.NoError(t, err)
+
+			var log string
+			if len(buf) >= tc.readLen {
+				log = string(buf[0:tc.readLen])
+			} else {
+				log = string(buf)
+			}
+
+			det, err := tc.cfg.compile()
+			require.NoError(t, err)
+
+			obtained := det.finished(log)
+			assert.Equal(t, tc.expected, obtained)
+		})
+	}
+}
+
+func TestReadyToken(t *testing.T) {
+	assert := assert.New(t)
+
+	token, err := newReadyToken()
+	require.NoError(t, err)
+	assert.Len(token, 32)
+
+	unit := readyTokenUnit("docker.service", token)
+	assert.Equal(readyTokenUnitName, unit.Name)
+	assert.Contains(unit.Contents, "After=docker.service\n")
+	assert.Contains(unit.Contents, "WantedBy=docker.service\n")
+	assert.Contains(unit.Contents, "fleeting-ready: "+token)
+
+	det := newTokenDetector(token)
+	assert.False(det.finished("fleeting-ready: 0000\r\nlogin: "))
+	assert.True(det.finished("[  OK  ] Started docker.service\r\nfleeting-ready: " + token + "\r\nlogin: "))
+}
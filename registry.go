@@ -0,0 +1,177 @@
+package fpoc
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	dockerDaemonPath    = "/etc/docker/daemon.json"
+	containerdCertsDir  = "/etc/containerd/certs.d"
+	podmanRegistriesCfg = "/etc/containers/registries.conf.d/90-fleeting-mirrors.conf"
+)
+
+// RegistryMirror pull-through mirrors of a registry
+type RegistryMirror struct {
+	Registry string   `json:"registry"` // optional: registry host, default docker.io
+	Mirrors  []string `json:"mirrors"`  // mirror URLs, e.g. https://mirror.example.com
+}
+
+// loadCACertificates reads PEM files and checks that they contain certificates
+func loadCACertificates(paths []string) ([]string, error) {
+	ret := make([]string, 0, len(paths))
+	for _, p := range paths {
+		buf, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+
+		rest := buf
+		count := 0
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+
+			_, err = x509.ParseCertificate(block.Bytes)
+			if block.Type != "CERTIFICATE" || err != nil {
+				return nil, fmt.Errorf("%s: not a PEM certificate", p)
+			}
+			count++
+		}
+		if count == 0 {
+			return nil, fmt.Errorf("%s: no PEM certificates found", p)
+		}
+
+		ret = append(ret, string(buf))
+	}
+
+	return ret, nil
+}
+
+// caTrustDir returns the directory of the OS trust store for the Ignition based image
+func (g *InstanceGroup) caTrustDir() string {
+	imgProps := g.imgProps.Load()
+	if imgProps != nil && strings.Contains(imgProps.OSDistro, "fedora") {
+		// Fedora CoreOS: coreos-update-ca-trust.service
+		return "/etc/pki/ca-trust/source/anchors"
+	}
+
+	// Flatcar: update-ca-certificates.service
+	return "/etc/ssl/certs"
+}
+
+func mirrorHost(mirror string) (string, error) {
+	u, err := url.Parse(mirror)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("invalid mirror URL %q", mirror)
+	}
+
+	return u.Host + u.Path, nil
+}
+
+// registryFiles returns docker, containerd and podman configs of the mirrors
+func registryFiles(mirrors []RegistryMirror) ([]FileSpec, error) {
+	var files []FileSpec
+	var dockerMirrors []string
+	var podman strings.Builder
+
+	for idx, rm := range mirrors {
+		registry := rm.Registry
+		if registry == "" {
+			registry = "docker.io"
+		}
+		if len(rm.Mirrors) == 0 {
+			return nil, fmt.Errorf("registry_mirrors[%d]: mirrors must be set", idx)
+		}
+
+		server := "https://" + registry
+		if registry == "docker.io" {
+			server = "https://registry-1.docker.io"
+			dockerMirrors = append(dockerMirrors, rm.Mirrors...)
+		}
+
+		var hosts strings.Builder
+		fmt.Fprintf(&hosts, "server = %q\n", server)
+		fmt.Fprintf(&podman, "[[registry]]\nprefix = %q\nlocation = %q\n", registry, registry)
+
+		for _, m := range rm.Mirrors {
+			host, err := mirrorHost(m)
+			if err != nil {
+				return nil, fmt.Errorf("registry_mirrors[%d]: %w", idx, err)
+			}
+
+			fmt.Fprintf(&hosts, "\n[host.%q]\n  capabilities = [\"pull\", \"resolve\"]\n", m)
+			fmt.Fprintf(&podman, "\n[[registry.mirror]]\nlocation = %q\n", host)
+			if strings.HasPrefix(m, "http://") {
+				fmt.Fprintf(&podman, "insecure = true\n")
+			}
+		}
+		podman.WriteString("\n")
+
+		files = append(files, FileSpec{
+			Path:    fmt.Sprintf("%s/%s/hosts.toml", containerdCertsDir, registry),
+			Mode:    0o644,
+			Content: hosts.String(),
+		})
+	}
+
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	files = append(files, FileSpec{Path: podmanRegistriesCfg, Mode: 0o644, Content: podman.String()})
+
+	if len(dockerMirrors) > 0 {
+		buf, err := json.MarshalIndent(map[string]any{"registry-mirrors": dockerMirrors}, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, FileSpec{Path: dockerDaemonPath, Mode: 0o644, Content: string(buf) + "\n"})
+	}
+
+	return files, nil
+}
+
+// registryExtras returns trusted CA certificates and registry mirror configs of the workers
+func (g *InstanceGroup) registryExtras() (*bootExtras, error) {
+	extras := &bootExtras{}
+	if g.isWindows() {
+		return extras, nil
+	}
+
+	certs, err := loadCACertificates(g.WorkerCACertificates)
+	if err != nil {
+		return nil, fmt.Errorf("worker_ca_certificates: %w", err)
+	}
+
+	if g.UseIgnition {
+		dir := g.caTrustDir()
+		for idx, cert := range certs {
+			extras.Files = append(extras.Files, FileSpec{
+				Path:    fmt.Sprintf("%s/fleeting-ca-%d.pem", dir, idx),
+				Mode:    0o644,
+				Content: cert,
+			})
+		}
+	} else {
+		// cloud-init ca_certs module knows the trust store of the distro
+		extras.CACerts = certs
+	}
+
+	files, err := registryFiles(g.RegistryMirrors)
+	if err != nil {
+		return nil, err
+	}
+	extras.Files = append(extras.Files, files...)
+
+	return extras, nil
+}
@@ -0,0 +1,130 @@
+package fpoc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestCA(t *testing.T, dir string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	p := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(p, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+
+	return p
+}
+
+func TestLoadCACertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca := writeTestCA(t, dir)
+
+	bad := filepath.Join(dir, "bad.pem")
+	require.NoError(t, os.WriteFile(bad, []byte("not a certificate"), 0o600))
+
+	certs, err := loadCACertificates([]string{ca})
+	require.NoError(t, err)
+	require.Len(t, certs, 1)
+	assert.Contains(t, certs[0], "-----BEGIN CERTIFICATE-----")
+
+	_, err = loadCACertificates([]string{bad})
+	assert.Error(t, err)
+
+	_, err = loadCACertificates([]string{filepath.Join(dir, "missing.pem")})
+	assert.Error(t, err)
+}
+
+func TestRegistryFiles(t *testing.T) {
+	files, err := registryFiles([]RegistryMirror{
+		{Mirrors: []string{"https://mirror.example.com"}},
+		{Registry: "quay.io", Mirrors: []string{"http://quay-mirror.local:5000/quay"}},
+	})
+	require.NoError(t, err)
+	require.Len(t, files, 4)
+
+	assert.Equal(t, FileSpec{Path: "/etc/containerd/certs.d/docker.io/hosts.toml", Mode: 0o644, Content: `server = "https://registry-1.docker.io"
+
+[host."https://mirror.example.com"]
+  capabilities = ["pull", "resolve"]
+`}, files[0])
+	assert.Equal(t, "/etc/containerd/certs.d/quay.io/hosts.toml", files[1].Path)
+	assert.Equal(t, FileSpec{Path: podmanRegistriesCfg, Mode: 0o644, Content: `[[registry]]
+prefix = "docker.io"
+location = "docker.io"
+
+[[registry.mirror]]
+location = "mirror.example.com"
+
+[[registry]]
+prefix = "quay.io"
+location = "quay.io"
+
+[[registry.mirror]]
+location = "quay-mirror.local:5000/quay"
+insecure = true
+
+`}, files[2])
+	assert.Equal(t, FileSpec{Path: dockerDaemonPath, Mode: 0o644, Content: "{\n  \"registry-mirrors\": [\n    \"https://mirror.example.com\"\n  ]\n}\n"}, files[3])
+
+	_, err = registryFiles([]RegistryMirror{{Mirrors: []string{"mirror.example.com"}}})
+	assert.Error(t, err)
+
+	_, err = registryFiles([]RegistryMirror{{Registry: "quay.io"}})
+	assert.Error(t, err)
+}
+
+func TestRegistryExtras(t *testing.T) {
+	ca := writeTestCA(t, t.TempDir())
+
+	t.Run("ignition", func(t *testing.T) {
+		g := &InstanceGroup{UseIgnition: true, WorkerCACertificates: []string{ca}}
+
+		extras, err := g.registryExtras()
+		require.NoError(t, err)
+		require.Len(t, extras.Files, 1)
+		assert.Equal(t, "/etc/ssl/certs/fleeting-ca-0.pem", extras.Files[0].Path)
+		assert.Empty(t, extras.CACerts)
+	})
+
+	t.Run("cloud-init", func(t *testing.T) {
+		g := &InstanceGroup{WorkerCACertificates: []string{ca}}
+
+		var err error
+		g.genExtras, err = g.registryExtras()
+		require.NoError(t, err)
+
+		spec := &ExtCreateOpts{}
+		err = g.mergeUserData(spec, g.staticExtras())
+		require.NoError(t, err)
+
+		ccs, err := cloudConfigs(spec.UserData)
+		require.NoError(t, err)
+		require.Len(t, ccs, 1)
+
+		caCerts, _ := ccs[0]["ca_certs"].(map[string]any)
+		assert.Len(t, caCerts["trusted"], 1)
+	})
+}
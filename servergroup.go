@@ -0,0 +1,324 @@
+package fpoc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servergroups"
+	"github.com/hashicorp/go-hclog"
+	"github.com/sardinasystems/fleeting-plugin-openstack/internal/openstackclient"
+)
+
+const (
+	PolicyAntiAffinity     = "anti-affinity"
+	PolicySoftAntiAffinity = "soft-anti-affinity"
+
+	defaultServerGroupMembers = 10 // default server_group_members quota
+	serverGroupSyncInterval   = time.Minute
+	serverGroupGrace          = 5 * time.Minute // empty group is kept that long after the last assignment
+)
+
+// ServerGroupConfig configures pool of server groups managed by the plugin
+type ServerGroupConfig struct {
+	Policy           string `json:"policy"`              // optional: anti-affinity (default) or soft-anti-affinity
+	MaxServerPerHost int    `json:"max_server_per_host"` // optional: anti-affinity rule, requires nova_microversion 2.64+
+	MaxMembers       int    `json:"max_members"`         // optional: instances per group, default 10
+}
+
+func (sc *ServerGroupConfig) parse(microversion string) error {
+	switch sc.Policy {
+	case "":
+		sc.Policy = PolicyAntiAffinity
+	case PolicyAntiAffinity, PolicySoftAntiAffinity:
+	default:
+		return fmt.Errorf("unknown policy: %s", sc.Policy)
+	}
+
+	if sc.MaxServerPerHost < 0 {
+		return fmt.Errorf("max_server_per_host must not be negative")
+	} else if sc.MaxServerPerHost > 0 && sc.Policy != PolicyAntiAffinity {
+		return fmt.Errorf("max_server_per_host requires %s policy", PolicyAntiAffinity)
+	} else if sc.MaxServerPerHost > 0 && microversionBefore(microversion, 2, 64) {
+		return fmt.Errorf("max_server_per_host requires nova_microversion 2.64 or later")
+	}
+
+	if sc.MaxMembers <= 0 {
+		sc.MaxMembers = defaultServerGroupMembers
+	}
+
+	return nil
+}
+
+// createOpts returns request of a new group, policies list is used before microversion 2.64
+func (sc *ServerGroupConfig) createOpts(name, microversion string) servergroups.CreateOpts {
+	opts := servergroups.CreateOpts{Name: name}
+
+	if microversionBefore(microversion, 2, 64) {
+		opts.Policies = []string{sc.Policy}
+		return opts
+	}
+
+	opts.Policy = sc.Policy
+	if sc.MaxServerPerHost > 0 {
+		opts.Rules = &servergroups.Rules{MaxServerPerHost: sc.MaxServerPerHost}
+	}
+
+	return opts
+}
+
+// microversionBefore tells if the microversion is set and older than major.minor
+func microversionBefore(microversion string, major, minor int) bool {
+	majorS, minorS, ok := strings.Cut(microversion, ".")
+	if !ok {
+		return false
+	}
+
+	mj, err1 := strconv.Atoi(majorS)
+	mn, err2 := strconv.Atoi(minorS)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+
+	return mj < major || (mj == major && mn < minor)
+}
+
+// serverGroupPool server groups of one backend, new groups are created when all are full
+type serverGroupPool struct {
+	cfg          *ServerGroupConfig
+	prefix       string // name prefix of the groups of the instance group
+	microversion string
+	log          hclog.Logger
+
+	createMu sync.Mutex // one group creation at a time, concurrent assignments reuse the new group
+
+	mu      sync.Mutex
+	members map[string]int       // group id -> members, as of last sync and creations since
+	pending map[string]int       // group id -> creation requests in flight
+	used    map[string]time.Time // group id -> last assignment or discovery
+}
+
+func newServerGroupPool(cfg *ServerGroupConfig, name, microversion string, log hclog.Logger) *serverGroupPool {
+	return &serverGroupPool{
+		cfg:          cfg,
+		prefix:       "fleeting-" + name + "-",
+		microversion: microversion,
+		log:          log.Named("server_groups"),
+		members:      make(map[string]int),
+		pending:      make(map[string]int),
+		used:         make(map[string]time.Time),
+	}
+}
+
+// owns tells if the group is managed by the pool: prefix followed by the random suffix
+func (sp *serverGroupPool) owns(name string) bool {
+	suffix, ok := strings.CutPrefix(name, sp.prefix)
+	return ok && len(suffix) == 6 && !strings.Contains(suffix, "-")
+}
+
+// assign returns group with room for a new instance, creates one if all are full.
+// Caller must call done when the creation request finished.
+func (sp *serverGroupPool) assign(ctx context.Context, client openstackclient.Client) (string, error) {
+	if groupID, ok := sp.reserve(); ok {
+		return groupID, nil
+	}
+
+	sp.createMu.Lock()
+	defer sp.createMu.Unlock()
+
+	// concurrent assignment may have created a group meanwhile
+	if groupID, ok := sp.reserve(); ok {
+		return groupID, nil
+	}
+
+	suffix, err := newRandomSuffix()
+	if err != nil {
+		return "", err
+	}
+
+	sg, err := client.CreateServerGroup(ctx, sp.cfg.createOpts(sp.prefix+suffix, sp.microversion))
+	if err != nil {
+		return "", fmt.Errorf("failed to create server group: %w", err)
+	}
+
+	sp.log.Info("Server group created", "group_id", sg.ID, "name", sg.Name, "policy", sp.cfg.Policy)
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	sp.members[sg.ID] = 0
+	sp.pending[sg.ID]++
+	sp.used[sg.ID] = time.Now()
+
+	return sg.ID, nil
+}
+
+// reserve takes room in the fullest group which is not full yet to keep the pool small, false if all are full
+func (sp *serverGroupPool) reserve() (string, bool) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	var groupID string
+	best := -1
+	for id, members := range sp.members {
+		count := members + sp.pending[id]
+		if count < sp.cfg.MaxMembers && (count > best || (count == best && id < groupID)) {
+			groupID, best = id, count
+		}
+	}
+
+	if groupID == "" {
+		return "", false
+	}
+
+	sp.pending[groupID]++
+	sp.used[groupID] = time.Now()
+
+	return groupID, true
+}
+
+// done finishes the assignment, created server becomes a member of the group
+func (sp *serverGroupPool) done(groupID string, created bool) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	sp.pending[groupID]--
+	if sp.pending[groupID] <= 0 {
+		delete(sp.pending, groupID)
+	}
+
+	if _, ok := sp.members[groupID]; ok && created {
+		sp.members[groupID]++
+	}
+}
+
+// sync reads members of the groups and deletes empty ones
+func (sp *serverGroupPool) sync(ctx context.Context, client openstackclient.Client) error {
+	groups, err := client.ListServerGroups(ctx)
+	if err != nil {
+		return err
+	}
+
+	empty := sp.update(groups)
+
+	// empty groups are out of the pool, so they are not assigned while being deleted
+	for _, sg := range empty {
+		err := client.DeleteServerGroup(ctx, sg.ID)
+		if err != nil && !gophercloud.ResponseCodeIs(err, http.StatusNotFound) {
+			sp.log.Warn("Failed to delete empty server group", "group_id", sg.ID, "err", err)
+
+			sp.mu.Lock()
+			if _, ok := sp.members[sg.ID]; !ok {
+				sp.members[sg.ID] = 0
+				sp.used[sg.ID] = time.Time{} // deletion is retried by the next sync
+			}
+			sp.mu.Unlock()
+			continue
+		}
+
+		sp.log.Info("Empty server group deleted", "group_id", sg.ID, "name", sg.Name)
+	}
+
+	return nil
+}
+
+// update replaces members of the pool by the listed groups, returns empty groups to delete removed from the pool
+func (sp *serverGroupPool) update(groups []servergroups.ServerGroup) []servergroups.ServerGroup {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	now := time.Now()
+	members := make(map[string]int)
+	var empty []servergroups.ServerGroup
+	for _, sg := range groups {
+		if !sp.owns(sg.Name) {
+			continue
+		}
+
+		if _, ok := sp.used[sg.ID]; !ok {
+			sp.used[sg.ID] = now
+		}
+
+		if len(sg.Members) == 0 && sp.pending[sg.ID] == 0 && now.Sub(sp.used[sg.ID]) > serverGroupGrace {
+			empty = append(empty, sg)
+			continue
+		}
+
+		members[sg.ID] = len(sg.Members)
+	}
+
+	// groups created after the listing are kept
+	for id, count := range sp.members {
+		if _, ok := members[id]; !ok && now.Sub(sp.used[id]) <= serverGroupGrace {
+			members[id] = count
+		}
+	}
+	for id := range sp.used {
+		if _, ok := members[id]; !ok {
+			delete(sp.used, id)
+		}
+	}
+
+	sp.members = members
+
+	return empty
+}
+
+// initServerGroupPools checks the config and creates the pool of each backend
+func (g *InstanceGroup) initServerGroupPools() error {
+	err := g.ServerGroups.parse(g.NovaMicroversion)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range g.getBackends() {
+		hints := g.ServerSpec.SchedulerHints
+		if b.spec != nil && b.spec.SchedulerHints != nil {
+			hints = b.spec.SchedulerHints
+		}
+		if hints != nil && hints.Group != "" {
+			return fmt.Errorf("only one of server_spec.scheduler_hints.group and server_groups may be set")
+		}
+
+		b.groups = newServerGroupPool(g.ServerGroups, g.Name, g.NovaMicroversion, g.log.With("backend", b.name))
+	}
+
+	return nil
+}
+
+// initServerGroups loads server groups of the backends and keeps them in sync in the background
+func (g *InstanceGroup) initServerGroups(ctx context.Context) {
+	syncAll := func(ctx context.Context) {
+		for _, b := range g.getBackends() {
+			if b.groups == nil {
+				continue
+			}
+
+			err := b.groups.sync(ctx, b.client)
+			if err != nil {
+				g.log.Warn("Failed to sync server groups", "backend", b.name, "err", err)
+			}
+		}
+	}
+
+	syncAll(ctx)
+
+	go func() {
+		ticker := time.NewTicker(serverGroupSyncInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-g.bgCtx.Done():
+				return
+			case <-ticker.C:
+				syncAll(g.bgCtx)
+			}
+		}
+	}()
+}
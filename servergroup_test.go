@@ -0,0 +1,186 @@
+package fpoc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servergroups"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerGroupConfig(t *testing.T) {
+	testCases := []struct {
+		name         string
+		cfg          ServerGroupConfig
+		microversion string
+		errMsg       string
+	}{
+		{"defaults", ServerGroupConfig{}, "", ""},
+		{"soft", ServerGroupConfig{Policy: PolicySoftAntiAffinity}, "2.15", ""},
+		{"rules", ServerGroupConfig{MaxServerPerHost: 2}, "2.79", ""},
+		{"unknown policy", ServerGroupConfig{Policy: "affinity"}, "", "unknown policy: affinity"},
+		{"rules with soft", ServerGroupConfig{Policy: PolicySoftAntiAffinity, MaxServerPerHost: 2}, "", "max_server_per_host requires anti-affinity policy"},
+		{"rules with old microversion", ServerGroupConfig{MaxServerPerHost: 2}, "2.60", "max_server_per_host requires nova_microversion 2.64 or later"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.cfg.parse(tc.microversion)
+			if tc.errMsg != "" {
+				assert.EqualError(t, err, tc.errMsg)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.NotEmpty(t, tc.cfg.Policy)
+			assert.Equal(t, defaultServerGroupMembers, tc.cfg.MaxMembers)
+		})
+	}
+}
+
+func TestServerGroupCreateOpts(t *testing.T) {
+	cfg := ServerGroupConfig{MaxServerPerHost: 2}
+	require.NoError(t, cfg.parse(""))
+
+	assert.Equal(t, servergroups.CreateOpts{
+		Name:   "fleeting-ci-abc123",
+		Policy: PolicyAntiAffinity,
+		Rules:  &servergroups.Rules{MaxServerPerHost: 2},
+	}, cfg.createOpts("fleeting-ci-abc123", "2.79"))
+
+	cfg = ServerGroupConfig{Policy: PolicySoftAntiAffinity}
+	require.NoError(t, cfg.parse("2.15"))
+
+	assert.Equal(t, servergroups.CreateOpts{
+		Name:     "fleeting-ci-abc123",
+		Policies: []string{PolicySoftAntiAffinity},
+	}, cfg.createOpts("fleeting-ci-abc123", "2.15"))
+}
+
+// fakeServerGroupClient keeps server groups in memory, records if the pool lock is held during the API calls
+type fakeServerGroupClient struct {
+	fakeLimitsClient
+
+	groups    []servergroups.ServerGroup
+	deleted   []string
+	deleteErr error
+	pool      *serverGroupPool
+	locked    bool
+}
+
+func (c *fakeServerGroupClient) checkLock() {
+	if c.pool == nil {
+		return
+	}
+
+	if !c.pool.mu.TryLock() {
+		c.locked = true
+		return
+	}
+	c.pool.mu.Unlock()
+}
+
+func (c *fakeServerGroupClient) ListServerGroups(ctx context.Context) ([]servergroups.ServerGroup, error) {
+	return c.groups, nil
+}
+
+func (c *fakeServerGroupClient) CreateServerGroup(ctx context.Context, opts servergroups.CreateOptsBuilder) (*servergroups.ServerGroup, error) {
+	c.checkLock()
+
+	o := opts.(servergroups.CreateOpts)
+	sg := servergroups.ServerGroup{ID: fmt.Sprintf("sg-%d", len(c.groups)+1), Name: o.Name}
+	c.groups = append(c.groups, sg)
+
+	return &sg, nil
+}
+
+func (c *fakeServerGroupClient) DeleteServerGroup(ctx context.Context, groupId string) error {
+	c.checkLock()
+
+	if c.deleteErr != nil {
+		return c.deleteErr
+	}
+
+	c.deleted = append(c.deleted, groupId)
+	return nil
+}
+
+func TestServerGroupPool(t *testing.T) {
+	ctx := context.Background()
+	cfg := ServerGroupConfig{MaxMembers: 2}
+	require.NoError(t, cfg.parse(""))
+
+	client := &fakeServerGroupClient{
+		groups: []servergroups.ServerGroup{
+			{ID: "other", Name: "fleeting-ci-x-abc123", Members: []string{"s0"}},
+			{ID: "full", Name: "fleeting-ci-abc123", Members: []string{"s1", "s2"}},
+		},
+	}
+
+	sp := newServerGroupPool(&cfg, "ci", "", hclog.NewNullLogger())
+	client.pool = sp
+	require.NoError(t, sp.sync(ctx, client))
+	assert.Equal(t, map[string]int{"full": 2}, sp.members)
+
+	// first group is full, new one is created
+	id, err := sp.assign(ctx, client)
+	require.NoError(t, err)
+	assert.Equal(t, "sg-3", id)
+	assert.True(t, sp.owns(client.groups[2].Name))
+
+	// pending creation takes room of the group
+	id2, err := sp.assign(ctx, client)
+	require.NoError(t, err)
+	assert.Equal(t, "sg-3", id2)
+
+	sp.done(id, true)
+	sp.done(id2, false)
+
+	// failed creation released the room
+	id, err = sp.assign(ctx, client)
+	require.NoError(t, err)
+	assert.Equal(t, "sg-3", id)
+	sp.done(id, true)
+
+	id, err = sp.assign(ctx, client)
+	require.NoError(t, err)
+	assert.Equal(t, "sg-4", id)
+	sp.done(id, true)
+
+	// members left, empty group is deleted after the grace time
+	client.groups = []servergroups.ServerGroup{
+		{ID: "full", Name: "fleeting-ci-abc123"},
+		{ID: "sg-3", Name: client.groups[2].Name, Members: []string{"s3"}},
+		{ID: "sg-4", Name: client.groups[3].Name},
+	}
+	require.NoError(t, sp.sync(ctx, client))
+	assert.Empty(t, client.deleted, "groups used recently")
+
+	// failed deletion keeps the group, it's retried by the next sync
+	sp.used["full"] = time.Now().Add(-2 * serverGroupGrace)
+	client.deleteErr = errors.New("delete failed")
+	require.NoError(t, sp.sync(ctx, client))
+	assert.Empty(t, client.deleted)
+	assert.Equal(t, map[string]int{"full": 0, "sg-3": 1, "sg-4": 0}, sp.members)
+
+	client.deleteErr = nil
+	require.NoError(t, sp.sync(ctx, client))
+	assert.Equal(t, []string{"full"}, client.deleted)
+	assert.Equal(t, map[string]int{"sg-3": 1, "sg-4": 0}, sp.members)
+
+	assert.False(t, client.locked, "pool lock held during the API calls")
+}
+
+func TestMicroversionBefore(t *testing.T) {
+	assert.False(t, microversionBefore("", 2, 64))
+	assert.False(t, microversionBefore("2.79", 2, 64))
+	assert.False(t, microversionBefore("2.64", 2, 64))
+	assert.True(t, microversionBefore("2.60", 2, 64))
+	assert.True(t, microversionBefore("2.9", 2, 64))
+	assert.False(t, microversionBefore("latest", 2, 64))
+}
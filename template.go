@@ -0,0 +1,193 @@
+package fpoc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TemplateData is available in name, description, user_data and metadata templates
+type TemplateData struct {
+	Index   int       // instance index
+	Cluster string    // name of the cluster
+	Suffix  string    // random suffix, unique for each instance
+	Created time.Time // creation timestamp
+	ImageID string    // image used for the instance
+	Flavor  string    // flavor used for the instance
+}
+
+var templateFuncs = template.FuncMap{
+	"b64enc": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"b64dec": func(s string) (string, error) {
+		buf, err := base64.StdEncoding.DecodeString(s)
+		return string(buf), err
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+}
+
+// specTemplates compiled templates of the server spec
+type specTemplates struct {
+	name        *template.Template
+	description *template.Template
+	userData    *template.Template
+	metadata    map[string]*template.Template
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s template: %w", name, err)
+	}
+
+	return tmpl, nil
+}
+
+// compileSpecTemplates parses templates of the spec.
+// Name without template actions keeps the old behavior: %d replaced with the index.
+func compileSpecTemplates(spec *ExtCreateOpts, userData bool) (*specTemplates, error) {
+	var err error
+	st := &specTemplates{
+		metadata: make(map[string]*template.Template),
+	}
+
+	if strings.Contains(spec.Name, "{{") {
+		st.name, err = parseTemplate("name", spec.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	st.description, err = parseTemplate("description", spec.Description)
+	if err != nil {
+		return nil, err
+	}
+
+	if userData {
+		st.userData, err = parseTemplate("user_data", spec.UserData)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for k, v := range spec.Metadata {
+		st.metadata[k], err = parseTemplate("metadata."+k, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return st, nil
+}
+
+func executeTemplate(tmpl *template.Template, data *TemplateData) (string, error) {
+	var buf bytes.Buffer
+
+	err := tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to render %s template: %w", tmpl.Name(), err)
+	}
+
+	return buf.String(), nil
+}
+
+// render sets templated fields of the spec
+func (st *specTemplates) render(spec *ExtCreateOpts, data *TemplateData) error {
+	var err error
+
+	if st.name != nil {
+		spec.Name, err = executeTemplate(st.name, data)
+	} else {
+		spec.Name = fmt.Sprintf(spec.Name, data.Index)
+	}
+	if err != nil {
+		return err
+	}
+
+	spec.Description, err = executeTemplate(st.description, data)
+	if err != nil {
+		return err
+	}
+
+	if st.userData != nil {
+		spec.UserData, err = executeTemplate(st.userData, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	for k, tmpl := range st.metadata {
+		spec.Metadata[k], err = executeTemplate(tmpl, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newRandomSuffix() (string, error) {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+	buf := make([]byte, 6)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate suffix: %w", err)
+	}
+
+	for idx, b := range buf {
+		buf[idx] = alphabet[int(b)%len(alphabet)]
+	}
+
+	return string(buf), nil
+}
+
+func (g *InstanceGroup) newTemplateData(index int, imageID, flavor string) (*TemplateData, error) {
+	suffix, err := newRandomSuffix()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TemplateData{
+		Index:   index,
+		Cluster: g.Name,
+		Suffix:  suffix,
+		Created: time.Now().UTC(),
+		ImageID: imageID,
+		Flavor:  flavor,
+	}, nil
+}
+
+// checkTemplates compiles templates of the spec and checks them with sample data, returns rendered sample spec
+func (g *InstanceGroup) checkTemplates(spec *ExtCreateOpts) (*specTemplates, *ExtCreateOpts, error) {
+	st, err := compileSpecTemplates(spec, g.UserDataTemplate)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := g.newTemplateData(0, spec.ImageRef, spec.FlavorRef)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sample := &ExtCreateOpts{
+		CreateOpts:  spec.CreateOpts,
+		Description: spec.Description,
+		UserData:    spec.UserData,
+	}
+	sample.Metadata = make(map[string]string)
+
+	err = st.render(sample, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return st, sample, nil
+}
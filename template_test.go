@@ -0,0 +1,97 @@
+package fpoc
+
+import (
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderSpecTemplates(t *testing.T) {
+	testCases := []struct {
+		name        string
+		spec        ExtCreateOpts
+		userData    bool
+		expName     string
+		expDesc     string
+		expUserData string
+		expMeta     map[string]string
+		isErr       bool
+	}{
+		{
+			name: "legacy-name",
+			// user data is not a template unless enabled
+			spec:        ExtCreateOpts{CreateOpts: servers.CreateOpts{Name: "runner-%d"}, UserData: "#!/bin/sh\necho {{ x }}\n"},
+			expName:     "runner-3",
+			expUserData: "#!/bin/sh\necho {{ x }}\n",
+			expMeta:     map[string]string{},
+		},
+		{
+			name: "templates",
+			spec: ExtCreateOpts{
+				CreateOpts: servers.CreateOpts{
+					Name:     "{{ .Cluster }}-{{ .Index }}-{{ .Flavor | upper }}",
+					Metadata: map[string]string{"group": "{{ .Cluster }}", "index": "{{ .Index }}"},
+				},
+				Description: "image {{ .ImageID }}",
+				UserData:    "#cloud-config\nhostname: {{ .Cluster }}{{ .Index }}\nwrite_files: [{path: /x, content: {{ b64enc .Cluster }}}]\n",
+			},
+			userData:    true,
+			expName:     "ci-3-M1.SMALL",
+			expDesc:     "image img-1",
+			expUserData: "#cloud-config\nhostname: ci3\nwrite_files: [{path: /x, content: Y2k=}]\n",
+			expMeta:     map[string]string{"group": "ci", "index": "3"},
+		},
+		{
+			name:  "unknown-field",
+			spec:  ExtCreateOpts{CreateOpts: servers.CreateOpts{Name: "{{ .Zone }}"}},
+			isErr: true,
+		},
+		{
+			name:     "parse-error",
+			spec:     ExtCreateOpts{CreateOpts: servers.CreateOpts{Name: "runner"}, UserData: "{{ if }}"},
+			userData: true,
+			isErr:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			g := &InstanceGroup{Name: "ci", ServerSpec: tc.spec, UserDataTemplate: tc.userData}
+			st, _, err := g.checkTemplates(&g.ServerSpec)
+			if tc.isErr {
+				assert.Error(err)
+				return
+			}
+			require.NoError(t, err)
+
+			spec := tc.spec
+			spec.Metadata = make(map[string]string)
+
+			data, err := g.newTemplateData(3, "img-1", "m1.small")
+			require.NoError(t, err)
+
+			err = st.render(&spec, data)
+			require.NoError(t, err)
+
+			assert.Equal(tc.expName, spec.Name)
+			assert.Equal(tc.expDesc, spec.Description)
+			assert.Equal(tc.expUserData, spec.UserData)
+			assert.Equal(tc.expMeta, spec.Metadata)
+		})
+	}
+}
+
+func TestNewRandomSuffix(t *testing.T) {
+	a, err := newRandomSuffix()
+	require.NoError(t, err)
+	b, err := newRandomSuffix()
+	require.NoError(t, err)
+
+	assert.Len(t, a, 6)
+	assert.Regexp(t, "^[a-z0-9]+$", a)
+	assert.NotEqual(t, a, b)
+}
@@ -0,0 +1,353 @@
+package fpoc
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	igncfg "github.com/coreos/ignition/v2/config/v3_4"
+	igntyp "github.com/coreos/ignition/v2/config/v3_4/types"
+	"gopkg.in/yaml.v3"
+)
+
+const cloudConfigHeader = "#cloud-config"
+
+// SystemdUnit describes systemd unit added to the instance boot config
+type SystemdUnit struct {
+	Name     string `json:"name"`
+	Enabled  bool   `json:"enabled"`
+	Contents string `json:"contents"`
+}
+
+// FileSpec describes file added to the instance boot config
+type FileSpec struct {
+	Path    string `json:"path"`
+	Mode    int    `json:"mode"`    // optional: default 0644
+	Content string `json:"content"` // file content
+	Source  string `json:"source"`  // or local file on the manager, read on Init
+}
+
+// load reads the source file and sets defaults
+func (fs *FileSpec) load() error {
+	if !path.IsAbs(fs.Path) {
+		return fmt.Errorf("path must be absolute: %q", fs.Path)
+	}
+
+	if fs.Source != "" {
+		if fs.Content != "" {
+			return fmt.Errorf("only one of content and source may be set: %s", fs.Path)
+		}
+
+		buf, err := os.ReadFile(fs.Source)
+		if err != nil {
+			return fmt.Errorf("failed to read source of %s: %w", fs.Path, err)
+		}
+
+		fs.Content = string(buf)
+		fs.Source = ""
+	}
+
+	if fs.Mode == 0 {
+		fs.Mode = 0o644
+	}
+
+	return nil
+}
+
+// bootExtras plugin generated additions merged into the user data
+type bootExtras struct {
+	Files    []FileSpec
+	Units    []SystemdUnit
+	Commands [][]string // Cloud-Init only: runcmd entries
+	CACerts  []string   // Cloud-Init only: ca_certs trusted entries
+}
+
+func (be *bootExtras) empty() bool {
+	return len(be.Files) == 0 && len(be.Units) == 0 && len(be.Commands) == 0 && len(be.CACerts) == 0
+}
+
+// append adds entries of other extras
+func (be *bootExtras) append(other *bootExtras) {
+	be.Files = append(be.Files, other.Files...)
+	be.Units = append(be.Units, other.Units...)
+	be.Commands = append(be.Commands, other.Commands...)
+	be.CACerts = append(be.CACerts, other.CACerts...)
+}
+
+// initExtras loads files and units configured for all instances and checks them against the user data
+func (g *InstanceGroup) initExtras(userData string) error {
+	for idx := range g.Files {
+		err := g.Files[idx].load()
+		if err != nil {
+			return fmt.Errorf("files[%d]: %w", idx, err)
+		}
+	}
+
+	for idx, unit := range g.SystemdUnits {
+		if unit.Name == "" {
+			return fmt.Errorf("systemd_units[%d]: name must be set", idx)
+		}
+	}
+
+	netExtras, err := g.networkExtras()
+	if err != nil {
+		return fmt.Errorf("network_config: %w", err)
+	}
+
+	regExtras, err := g.registryExtras()
+	if err != nil {
+		return err
+	}
+
+	g.genExtras = &bootExtras{}
+	g.genExtras.append(netExtras)
+	g.genExtras.append(regExtras)
+
+	extras := g.staticExtras()
+	if extras.empty() {
+		return nil
+	}
+
+	return g.checkCollisions(userData, extras)
+}
+
+// staticExtras returns a copy of files and units configured or generated for all instances
+func (g *InstanceGroup) staticExtras() *bootExtras {
+	extras := &bootExtras{
+		Files: slices.Clone(g.Files),
+		Units: slices.Clone(g.SystemdUnits),
+	}
+
+	if g.genExtras != nil {
+		extras.append(g.genExtras)
+	}
+
+	return extras
+}
+
+// checkCollisions reports files and units of extras already present in the user data
+func (g *InstanceGroup) checkCollisions(userData string, extras *bootExtras) error {
+	paths := make(map[string]struct{})
+	units := make(map[string]struct{})
+
+	if g.UseIgnition {
+		cfg, err := parseIgnition(userData)
+		if err != nil {
+			return err
+		}
+
+		for _, f := range cfg.Storage.Files {
+			paths[f.Path] = struct{}{}
+		}
+		for _, u := range cfg.Systemd.Units {
+			units[u.Name] = struct{}{}
+		}
+	} else {
+		ccs, err := cloudConfigs(userData)
+		if err != nil {
+			return err
+		}
+
+		for _, cc := range ccs {
+			wfs, _ := cc["write_files"].([]any)
+			for _, wf := range wfs {
+				wfm, _ := wf.(map[string]any)
+				if p, ok := wfm["path"].(string); ok {
+					paths[p] = struct{}{}
+				}
+			}
+		}
+	}
+
+	var err error
+	for _, f := range extras.Files {
+		if _, ok := paths[f.Path]; ok {
+			err = errors.Join(err, fmt.Errorf("file %s already defined in user data", f.Path))
+		}
+		paths[f.Path] = struct{}{}
+	}
+	for _, u := range extras.Units {
+		if _, ok := units[u.Name]; ok {
+			err = errors.Join(err, fmt.Errorf("unit %s already defined in user data", u.Name))
+		}
+		if _, ok := paths[unitPath(u.Name)]; ok {
+			err = errors.Join(err, fmt.Errorf("unit file %s already defined in user data", unitPath(u.Name)))
+		}
+		units[u.Name] = struct{}{}
+	}
+
+	return err
+}
+
+func unitPath(name string) string {
+	return "/etc/systemd/system/" + name
+}
+
+func parseIgnition(userData string) (igntyp.Config, error) {
+	var cfg igntyp.Config
+	var err error
+
+	if userData != "" {
+		// report is checked by validateIgnition on Init
+		cfg, _, err = igncfg.ParseCompatibleVersion([]byte(userData))
+		if err != nil {
+			return cfg, fmt.Errorf("failed to parse ignition: %w", err)
+		}
+	}
+
+	if cfg.Ignition.Version == "" {
+		cfg.Ignition.Version = igntyp.MaxVersion.String()
+	}
+
+	return cfg, nil
+}
+
+// storeIgnition sets the config as user data of the spec, translated to the spec version (latest if empty)
+func storeIgnition(spec *ExtCreateOpts, cfg igntyp.Config, version string) error {
+	buf, err := translateIgnition(cfg, version)
+	if err != nil {
+		return err
+	}
+
+	spec.UserData = string(buf)
+	return nil
+}
+
+func insertExtrasIgn(cfg *igntyp.Config, extras *bootExtras) {
+	for _, f := range extras.Files {
+		source := "data:;base64," + base64.StdEncoding.EncodeToString([]byte(f.Content))
+		overwrite := true
+
+		cfg.Storage.Files = append(cfg.Storage.Files, igntyp.File{
+			Node: igntyp.Node{
+				Path:      f.Path,
+				Overwrite: &overwrite,
+			},
+			FileEmbedded1: igntyp.FileEmbedded1{
+				Contents: igntyp.Resource{Source: &source},
+				Mode:     &f.Mode,
+			},
+		})
+	}
+
+	for _, unit := range extras.Units {
+		cfg.Systemd.Units = append(cfg.Systemd.Units, igntyp.Unit{
+			Name:     unit.Name,
+			Enabled:  &unit.Enabled,
+			Contents: &unit.Contents,
+		})
+	}
+}
+
+// parseCloudConfig parses cloud-config document, empty user data gives empty config
+func parseCloudConfig(userData string) (map[string]any, error) {
+	cc := make(map[string]any)
+	if strings.TrimSpace(userData) == "" {
+		return cc, nil
+	}
+
+	err := yaml.Unmarshal([]byte(userData), &cc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cloud-config: %w", err)
+	}
+	if cc == nil {
+		cc = make(map[string]any)
+	}
+
+	return cc, nil
+}
+
+// appendList appends items to the list under the key
+func appendList(cc map[string]any, key string, items ...any) {
+	list, _ := cc[key].([]any)
+	cc[key] = append(list, items...)
+}
+
+func insertExtrasCloudConfig(cc map[string]any, extras *bootExtras) {
+	for _, f := range extras.Files {
+		appendList(cc, "write_files", map[string]any{
+			"path":        f.Path,
+			"permissions": fmt.Sprintf("%#o", f.Mode),
+			"content":     f.Content,
+		})
+	}
+
+	for _, unit := range extras.Units {
+		appendList(cc, "write_files", map[string]any{
+			"path":        unitPath(unit.Name),
+			"permissions": "0644",
+			"content":     unit.Contents,
+		})
+
+		if unit.Enabled {
+			appendList(cc, "runcmd", []any{"systemctl", "enable", "--now", "--no-block", unit.Name})
+		}
+	}
+
+	if len(extras.CACerts) > 0 {
+		trusted := make([]any, 0, len(extras.CACerts))
+		for _, cert := range extras.CACerts {
+			trusted = append(trusted, cert)
+		}
+		cc["ca_certs"] = map[string]any{"trusted": trusted}
+	}
+
+	for _, cmd := range extras.Commands {
+		args := make([]any, 0, len(cmd))
+		for _, arg := range cmd {
+			args = append(args, arg)
+		}
+		appendList(cc, "runcmd", args)
+	}
+}
+
+// mergeUserData adds plugin generated parts into the spec user data
+func (g *InstanceGroup) mergeUserData(spec *ExtCreateOpts, extras *bootExtras) error {
+	if g.UseIgnition {
+		cfg, err := parseIgnition(spec.UserData)
+		if err != nil {
+			return err
+		}
+
+		insertSSHKeyIgn(&cfg, g.settings.Username, g.sshPubKey)
+		insertExtrasIgn(&cfg, extras)
+
+		version, err := g.ignitionVersion()
+		if err != nil {
+			return err
+		}
+
+		return storeIgnition(spec, cfg, version)
+	}
+
+	if g.isWindows() {
+		// cloudbase-init do not support most of the cloud-config modules
+		return nil
+	}
+
+	cc := make(map[string]any)
+	insertExtrasCloudConfig(cc, extras)
+
+	if g.sshPubKey != "" {
+		appendList(cc, "ssh_authorized_keys", strings.TrimSpace(g.sshPubKey))
+	}
+	if spec.Name != "" {
+		cc["hostname"] = spec.Name
+	}
+
+	if len(cc) == 0 {
+		return nil
+	}
+
+	buf, err := yaml.Marshal(cc)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cloud-config: %w", err)
+	}
+
+	spec.UserData, err = appendCloudConfigPart(spec.UserData, cloudConfigHeader+"\n"+string(buf))
+	return err
+}
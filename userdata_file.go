@@ -0,0 +1,126 @@
+package fpoc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/jinzhu/copier"
+)
+
+// userDataFilePath returns the user data file of the spec
+func userDataFilePath(spec *ExtCreateOpts) string {
+	if spec.UserDataButaneFile != "" {
+		return spec.UserDataButaneFile
+	}
+
+	return spec.UserDataFile
+}
+
+// loadUserDataFile reads user_data_file or user_data_butane_file into the spec, returns hash of the content
+func loadUserDataFile(spec *ExtCreateOpts) (string, error) {
+	if spec.UserDataFile == "" && spec.UserDataButaneFile == "" {
+		return "", nil
+	}
+
+	if spec.UserDataFile != "" && spec.UserDataButaneFile != "" {
+		return "", fmt.Errorf("only one of user_data_file and user_data_butane_file may be set")
+	}
+	if spec.UserData != "" || spec.UserDataButane != "" {
+		return "", fmt.Errorf("user data file may not be used with user_data or user_data_butane")
+	}
+
+	buf, err := os.ReadFile(userDataFilePath(spec))
+	if err != nil {
+		return "", fmt.Errorf("failed to read user data file: %w", err)
+	}
+
+	if spec.UserDataButaneFile != "" {
+		spec.UserDataButane = string(buf)
+	} else {
+		spec.UserData = string(buf)
+	}
+
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// reloadUserData re-reads the user data file if its content changed since the last load.
+// Must be called with specMu held.
+func (g *InstanceGroup) reloadUserData(_ context.Context) error {
+	if g.userDataHash == "" {
+		return nil
+	}
+
+	spec := &ExtCreateOpts{
+		CreateOpts:         g.ServerSpec.CreateOpts,
+		Description:        g.ServerSpec.Description,
+		UserDataFile:       g.ServerSpec.UserDataFile,
+		UserDataButaneFile: g.ServerSpec.UserDataButaneFile,
+	}
+
+	hash, err := loadUserDataFile(spec)
+	if err != nil {
+		return err
+	}
+	if hash == g.userDataHash {
+		return nil
+	}
+
+	err = g.transpileUserData(spec)
+	if err != nil {
+		return fmt.Errorf("failed to transpile butane user data: %w", err)
+	}
+
+	st, sample, err := g.checkTemplates(spec)
+	if err != nil {
+		return fmt.Errorf("failed to check server_spec templates: %w", err)
+	}
+
+	err = g.checkUserData(sample.UserData)
+	if err != nil {
+		return fmt.Errorf("failed to validate user data: %w", err)
+	}
+
+	extras := g.staticExtras()
+	if !extras.empty() {
+		err = g.checkCollisions(sample.UserData, extras)
+		if err != nil {
+			return fmt.Errorf("failed to check files and systemd_units: %w", err)
+		}
+	}
+
+	err = g.checkUserDataSize(sample)
+	if err != nil {
+		return err
+	}
+
+	g.ServerSpec.UserData = spec.UserData
+	g.templates = st
+	g.userDataHash = hash
+
+	g.log.Info("User data file changed, reloaded", "path", userDataFilePath(spec), "sha256", hash)
+
+	return nil
+}
+
+// currentSpec returns a copy of the server spec with up to date user data and its templates
+func (g *InstanceGroup) currentSpec(ctx context.Context) (*ExtCreateOpts, *specTemplates, error) {
+	g.specMu.Lock()
+	defer g.specMu.Unlock()
+
+	err := g.reloadUserData(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	spec := new(ExtCreateOpts)
+	err = copier.CopyWithOption(spec, &g.ServerSpec, copier.Option{DeepCopy: true})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return spec, g.templates, nil
+}
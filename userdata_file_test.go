@@ -0,0 +1,101 @@
+package fpoc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadUserDataFile(t *testing.T) {
+	dir := t.TempDir()
+	fpath := filepath.Join(dir, "user-data")
+	require.NoError(t, os.WriteFile(fpath, []byte("#cloud-config\n"), 0o600))
+
+	testCases := []struct {
+		name      string
+		spec      ExtCreateOpts
+		expData   string
+		expButane string
+		isErr     bool
+	}{
+		{"none", ExtCreateOpts{UserData: "#cloud-config\n"}, "#cloud-config\n", "", false},
+		{"file", ExtCreateOpts{UserDataFile: fpath}, "#cloud-config\n", "", false},
+		{"butane-file", ExtCreateOpts{UserDataButaneFile: fpath}, "", "#cloud-config\n", false},
+		{"both-files", ExtCreateOpts{UserDataFile: fpath, UserDataButaneFile: fpath}, "", "", true},
+		{"with-inline", ExtCreateOpts{UserData: "x", UserDataFile: fpath}, "", "", true},
+		{"missing", ExtCreateOpts{UserDataFile: filepath.Join(dir, "missing")}, "", "", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			hash, err := loadUserDataFile(&tc.spec)
+			if tc.isErr {
+				assert.Error(err)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(tc.expData, tc.spec.UserData)
+			assert.Equal(tc.expButane, tc.spec.UserDataButane)
+			if tc.spec.UserDataFile != "" || tc.spec.UserDataButaneFile != "" {
+				assert.Len(hash, 64)
+			} else {
+				assert.Empty(hash)
+			}
+		})
+	}
+}
+
+func TestReloadUserData(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	fpath := filepath.Join(t.TempDir(), "user-data")
+	require.NoError(t, os.WriteFile(fpath, []byte("#cloud-config\nhostname: a{{ .Index }}\n"), 0o600))
+
+	g := &InstanceGroup{
+		Name:             "ci",
+		ServerSpec:       ExtCreateOpts{CreateOpts: servers.CreateOpts{Name: "runner-%d"}, UserDataFile: fpath},
+		UserDataTemplate: true,
+		log:              hclog.NewNullLogger(),
+	}
+
+	var err error
+	g.userDataHash, err = loadUserDataFile(&g.ServerSpec)
+	require.NoError(t, err)
+	g.templates, _, err = g.checkTemplates(&g.ServerSpec)
+	require.NoError(t, err)
+	hash := g.userDataHash
+
+	spec, st, err := g.currentSpec(ctx)
+	require.NoError(t, err)
+	assert.Equal(hash, g.userDataHash)
+	assert.Equal("#cloud-config\nhostname: a{{ .Index }}\n", spec.UserData)
+
+	require.NoError(t, os.WriteFile(fpath, []byte("#cloud-config\nhostname: b{{ .Index }}\n"), 0o600))
+
+	spec, st2, err := g.currentSpec(ctx)
+	require.NoError(t, err)
+	assert.NotEqual(hash, g.userDataHash)
+	assert.NotSame(st, st2)
+
+	data, err := g.newTemplateData(2, "", "")
+	require.NoError(t, err)
+	require.NoError(t, st2.render(spec, data))
+	assert.Equal("#cloud-config\nhostname: b2\n", spec.UserData)
+
+	// broken update keeps the previous user data
+	require.NoError(t, os.WriteFile(fpath, []byte("{{ if }}"), 0o600))
+
+	_, _, err = g.currentSpec(ctx)
+	assert.Error(err)
+	assert.Equal("#cloud-config\nhostname: b{{ .Index }}\n", g.ServerSpec.UserData)
+}
@@ -0,0 +1,135 @@
+package fpoc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/fleeting/fleeting/provider"
+)
+
+func TestMergeUserData(t *testing.T) {
+	extras := &bootExtras{
+		Units: []SystemdUnit{
+			{Name: "test.service", Enabled: true, Contents: "[Service]\nExecStart=/bin/true\n"},
+		},
+	}
+
+	testCases := []struct {
+		name        string
+		useIgnition bool
+		userData    string
+		extras      *bootExtras
+		expected    string
+		isErr       bool
+	}{
+		{"ign-empty", true, "", &bootExtras{}, `{"ignition":{"config":{"replace":{"verification":{}}},"proxy":{},"security":{"tls":{}},"timeouts":{},"version":"3.4.0"},"kernelArguments":{},"passwd":{"users":[{"name":"test","sshAuthorizedKeys":["testkey"]}]},"storage":{},"systemd":{}}`, false},
+		{"ign-units", true, `{"ignition":{"version":"3.3.0"},"systemd":{"units":[{"name":"docker.service","enabled":true}]}}`, extras, `{"ignition":{"config":{"replace":{"verification":{}}},"proxy":{},"security":{"tls":{}},"timeouts":{},"version":"3.4.0"},"kernelArguments":{},"passwd":{"users":[{"name":"test","sshAuthorizedKeys":["testkey"]}]},"storage":{},"systemd":{"units":[{"enabled":true,"name":"docker.service"},{"contents":"[Service]\nExecStart=/bin/true\n","enabled":true,"name":"test.service"}]}}`, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			g := &InstanceGroup{
+				UseIgnition: tc.useIgnition,
+				settings:    provider.Settings{ConnectorConfig: provider.ConnectorConfig{Username: "test"}},
+				sshPubKey:   "testkey",
+			}
+			spec := &ExtCreateOpts{
+				UserData: tc.userData,
+			}
+
+			err := g.mergeUserData(spec, tc.extras)
+			if tc.isErr {
+				assert.Error(err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(tc.expected, spec.UserData)
+		})
+	}
+}
+
+func TestMergeUserDataFiles(t *testing.T) {
+	extras := &bootExtras{
+		Files: []FileSpec{
+			{Path: "/etc/flatcar/update.conf", Mode: 0o644, Content: "SERVER=disabled\n"},
+		},
+	}
+
+	testCases := []struct {
+		name        string
+		useIgnition bool
+		expected    string
+	}{
+		{"ignition", true, `{"ignition":{"config":{"replace":{"verification":{}}},"proxy":{},"security":{"tls":{}},"timeouts":{},"version":"3.4.0"},"kernelArguments":{},"passwd":{"users":[{"name":"test","sshAuthorizedKeys":["testkey"]}]},"storage":{"files":[{"group":{},"overwrite":true,"path":"/etc/flatcar/update.conf","user":{},"contents":{"source":"data:;base64,U0VSVkVSPWRpc2FibGVkCg==","verification":{}},"mode":420}]},"systemd":{}}`},
+		{"cloud-config", false, "Content-Type: multipart/mixed; boundary=\"==FLEETING-PLUGIN-OPENSTACK==\"\r\nMIME-Version: 1.0\r\n\r\n--==FLEETING-PLUGIN-OPENSTACK==\r\nContent-Disposition: attachment; filename=\"fleeting-plugin-openstack.cfg\"\r\nContent-Type: text/cloud-config; charset=\"utf-8\"\r\nMerge-Type: list(append)+dict(no_replace,recurse_list)+str()\r\n\r\n#cloud-config\nssh_authorized_keys:\n    - testkey\nwrite_files:\n    - content: |\n        SERVER=disabled\n      path: /etc/flatcar/update.conf\n      permissions: \"0644\"\n\r\n--==FLEETING-PLUGIN-OPENSTACK==--\r\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := &InstanceGroup{
+				UseIgnition: tc.useIgnition,
+				settings:    provider.Settings{ConnectorConfig: provider.ConnectorConfig{Username: "test"}},
+				sshPubKey:   "testkey",
+			}
+			spec := &ExtCreateOpts{}
+
+			err := g.mergeUserData(spec, extras)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, spec.UserData)
+		})
+	}
+}
+
+func TestInitExtras(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "update.conf")
+	require.NoError(t, os.WriteFile(src, []byte("SERVER=disabled\n"), 0o600))
+
+	testCases := []struct {
+		name        string
+		useIgnition bool
+		userData    string
+		files       []FileSpec
+		units       []SystemdUnit
+		isErr       bool
+	}{
+		{"ok-ignition", true, `{"ignition":{"version":"3.4.0"},"systemd":{"units":[{"name":"docker.service","enabled":true}]}}`, []FileSpec{{Path: "/etc/flatcar/update.conf", Source: src}}, []SystemdUnit{{Name: "test.service"}}, false},
+		{"ok-cloud-config", false, "#cloud-config\nwrite_files:\n  - path: /etc/motd\n    content: hello\n", []FileSpec{{Path: "/etc/flatcar/update.conf", Content: "x"}}, nil, false},
+		{"relative-path", true, "", []FileSpec{{Path: "etc/motd"}}, nil, true},
+		{"no-source", true, "", []FileSpec{{Path: "/etc/motd", Source: "/nonexistent"}}, nil, true},
+		{"source-and-content", true, "", []FileSpec{{Path: "/etc/motd", Source: src, Content: "x"}}, nil, true},
+		{"unit-no-name", true, "", nil, []SystemdUnit{{Contents: "x"}}, true},
+		{"file-collision-ignition", true, `{"ignition":{"version":"3.4.0"},"storage":{"files":[{"path":"/etc/motd"}]}}`, []FileSpec{{Path: "/etc/motd"}}, nil, true},
+		{"unit-collision-ignition", true, `{"ignition":{"version":"3.4.0"},"systemd":{"units":[{"name":"docker.service","enabled":true}]}}`, nil, []SystemdUnit{{Name: "docker.service"}}, true},
+		{"file-collision-cloud-config", false, "#cloud-config\nwrite_files:\n  - path: /etc/motd\n    content: hello\n", []FileSpec{{Path: "/etc/motd"}}, nil, true},
+		{"unit-collision-cloud-config", false, "#cloud-config\nwrite_files:\n  - path: /etc/systemd/system/test.service\n", nil, []SystemdUnit{{Name: "test.service"}}, true},
+		{"script", false, "#!/bin/sh\n", []FileSpec{{Path: "/etc/motd", Content: "x"}}, nil, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := &InstanceGroup{
+				UseIgnition:  tc.useIgnition,
+				ServerSpec:   ExtCreateOpts{UserData: tc.userData},
+				Files:        tc.files,
+				SystemdUnits: tc.units,
+			}
+
+			err := g.initExtras(tc.userData)
+			if tc.isErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			for _, f := range g.Files {
+				assert.NotEmpty(t, f.Content)
+				assert.Equal(t, 0o644, f.Mode)
+			}
+		})
+	}
+}
@@ -1,15 +1,11 @@
 package fpoc
 
 import (
-	"encoding/json"
-	"fmt"
 	"maps"
 	"regexp"
 	"strings"
 
-	igncfg "github.com/coreos/ignition/v2/config/v3_4"
 	igntyp "github.com/coreos/ignition/v2/config/v3_4/types"
-	"github.com/coreos/vcontext/report"
 	"github.com/go-viper/mapstructure/v2"
 	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
 )
@@ -26,11 +22,20 @@ type ExtCreateOpts struct {
 	// search for imageRef by name each time
 	ImageName string `json:"image_name,omitempty"`
 
+	// ordered flavors, the next one is used if the server failed to schedule
+	FlavorRefs []string `json:"flavorRefs,omitempty"`
+
 	// annotation overrides
-	Networks       []servers.Network          `json:"networks,omitempty"`
-	SecurityGroups []string                   `json:"security_groups,omitempty"`
-	UserData       string                     `json:"user_data,omitempty"`
-	SchedulerHints *servers.SchedulerHintOpts `json:"scheduler_hints,omitempty"`
+	Networks           []servers.Network          `json:"networks,omitempty"`
+	SecurityGroups     []string                   `json:"security_groups,omitempty"`
+	UserData           string                     `json:"user_data,omitempty"`
+	UserDataButane     string                     `json:"user_data_butane,omitempty"`      // transpiled into user_data on Init
+	UserDataFile       string                     `json:"user_data_file,omitempty"`        // user_data read from the file, reloaded on change
+	UserDataButaneFile string                     `json:"user_data_butane_file,omitempty"` // user_data_butane read from the file, reloaded on change
+	SchedulerHints     *servers.SchedulerHintOpts `json:"scheduler_hints,omitempty"`
+
+	// set by the plugin for multi-create requests
+	ReturnReservationID bool `json:"-"`
 }
 
 // ToServerCreateMap for extended opts
@@ -59,6 +64,9 @@ func (opts ExtCreateOpts) ToServerCreateMap() (map[string]interface{}, error) {
 	if opts.KeyName != "" {
 		b["key_name"] = opts.KeyName
 	}
+	if opts.ReturnReservationID {
+		b["return_reservation_id"] = true
+	}
 
 	sob := ob["server"].(map[string]any)
 	maps.Copy(sob, b)
@@ -107,6 +115,7 @@ var (
 	initFinishedRe   = regexp.MustCompile(`^.*Cloud-init\ v\.\ \S+\ finished\ at.*$`)
 	initSSHHostKeyRe = regexp.MustCompile(`^SSH\ host\ key:\ \S+:\S+\ (\S+)$`)
 	initLoginRe      = regexp.MustCompile(`^\S+\ login:\ .*$`)
+	cbInitFinishedRe = regexp.MustCompile(`^.*\ cloudbaseinit\.init\ \[-\]\ Plugins\ execution\ done.*$`)
 )
 
 func IsCloudInitFinished(log string) bool {
@@ -143,25 +152,32 @@ func IsIgnitionFinished(log string) bool {
 	return false
 }
 
-func InsertSSHKeyIgn(spec *ExtCreateOpts, username, pubKey string) error {
-	var cfg igntyp.Config
-	var err error
-
-	if spec.UserData != "" {
-		var rpt report.Report
+// IsCloudbaseInitFinished checks cloudbase-init log for the end of plugins execution.
+// Note that cloudbase-init only logs to the console if logging_serial_port_settings is set.
+func IsCloudbaseInitFinished(log string) bool {
+	lines := strings.Split(log, "\n")
 
-		cfg, rpt, err = igncfg.ParseCompatibleVersion([]byte(spec.UserData))
-		if err != nil {
-			return fmt.Errorf("failed to parse ignition: %w", err)
+	// "Rebooting" may be logged instead, then plugins continue to execute after restart
+	for _, line := range lines {
+		if cbInitFinishedRe.MatchString(line) {
+			return true
 		}
-
-		_ = rpt
 	}
+	return false
+}
 
-	if cfg.Ignition.Version == "" {
-		cfg.Ignition.Version = igntyp.MaxVersion.String()
+func InsertSSHKeyIgn(spec *ExtCreateOpts, username, pubKey string) error {
+	cfg, err := parseIgnition(spec.UserData)
+	if err != nil {
+		return err
 	}
 
+	insertSSHKeyIgn(&cfg, username, pubKey)
+
+	return storeIgnition(spec, cfg, "")
+}
+
+func insertSSHKeyIgn(cfg *igntyp.Config, username, pubKey string) {
 	var user *igntyp.PasswdUser
 	if cfg.Passwd.Users == nil {
 		cfg.Passwd.Users = make([]igntyp.PasswdUser, 0)
@@ -183,12 +199,4 @@ func InsertSSHKeyIgn(spec *ExtCreateOpts, username, pubKey string) error {
 	}
 
 	user.SSHAuthorizedKeys = append(user.SSHAuthorizedKeys, igntyp.SSHAuthorizedKey(pubKey))
-
-	buf, err := json.Marshal(cfg)
-	if err != nil {
-		return fmt.Errorf("failed to marshal ignition: %w", err)
-	}
-
-	spec.UserData = string(buf)
-	return nil
 }
@@ -69,6 +69,36 @@ func TestIsIgnitionFinished(t *testing.T) {
 	}
 }
 
+func TestIsCloudbaseInitFinished(t *testing.T) {
+	testCases := []struct {
+		name     string
+		file     string
+		readLen  int
+		expected bool
+	}{
+		{"token-not-fond-1", "testdata/console_windows2022.txt", 4096, false},
+		{"finished-1", "testdata/console_windows2022.txt", 102400, true},
+		{"cloud-init", "testdata/console_ubuntu2204.txt", 102400, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf, err := os.ReadFile(tc.file)
+			require.NoError(t, err)
+
+			var log string
+			if len(buf) >= tc.readLen {
+				log = string(buf[0:tc.readLen])
+			} else {
+				log = string(buf)
+			}
+
+			obtained := IsCloudbaseInitFinished(log)
+			assert.Equal(t, tc.expected, obtained)
+		})
+	}
+}
+
 func TestExtCreateOpts(t *testing.T) {
 	assert := assert.New(t)
 
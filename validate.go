@@ -0,0 +1,115 @@
+package fpoc
+
+import (
+	"fmt"
+	"maps"
+	"mime"
+	"slices"
+	"strings"
+
+	igncfg "github.com/coreos/ignition/v2/config/v3_4"
+	"github.com/coreos/vcontext/report"
+)
+
+// cloudConfigKeys top-level keys of cloud-init modules (and cloudbase-init plugins)
+var cloudConfigKeys = []string{
+	"allow_public_ssh_keys", "ansible", "apk_repos", "apt", "apt_pipelining", "apt_reboot_if_required",
+	"apt_update", "apt_upgrade", "autoinstall", "bootcmd", "byobu_by_default", "ca-certs", "ca_certs",
+	"chef", "chpasswd", "cloud_config_modules", "cloud_final_modules", "cloud_init_modules",
+	"create_hostname_file", "datasource", "device_aliases", "disable_ec2_metadata", "disable_root",
+	"disable_root_opts", "disk_setup", "drivers", "fan", "final_message", "fqdn", "fs_setup", "groups",
+	"growpart", "grub-dpkg", "grub_dpkg", "hostname", "keyboard", "keys_to_console", "landscape",
+	"launch_index", "locale", "locale_configfile", "lxd", "manage_etc_hosts", "manage_resolv_conf",
+	"mcollective", "merge_how", "merge_type", "mount_default_fields", "mounts", "no_ssh_fingerprints",
+	"ntp", "output", "package_reboot_if_required", "package_update", "package_upgrade", "packages",
+	"password", "phone_home", "power_state", "prefer_fqdn_over_hostname", "preserve_hostname", "puppet",
+	"random_seed", "reporting", "resize_rootfs", "resolv_conf", "rh_subscription", "rsyslog", "runcmd",
+	"salt_minion", "set_hostname", "set_timezone", "snap", "spacewalk", "ssh", "ssh_authorized_keys",
+	"ssh_deletekeys", "ssh_fp_console_blacklist", "ssh_genkeytypes", "ssh_import_id",
+	"ssh_key_console_blacklist", "ssh_keys", "ssh_publish_hostkeys", "ssh_pwauth", "ssh_quiet_keygen",
+	"ssh_redirect_user", "swap", "system_info", "timezone", "ubuntu_advantage", "ubuntu_drivers",
+	"ubuntu_pro", "updates", "user", "users", "vendor_data", "wireguard", "write_files", "yum_repo_dir",
+	"yum_repos", "zypper",
+}
+
+// validateIgnition parses Ignition config, returns warnings of the report
+func validateIgnition(userData string) ([]string, error) {
+	_, rpt, err := igncfg.ParseCompatibleVersion([]byte(userData))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ignition: %w: %s", err, rpt.String())
+	}
+
+	var warnings []string
+	for _, e := range rpt.Entries {
+		if e.Kind == report.Warn {
+			warnings = append(warnings, e.String())
+		}
+	}
+
+	return warnings, nil
+}
+
+// validateCloudConfig parses #cloud-config parts of the user data, returns unknown top-level keys as warnings
+func validateCloudConfig(userData string) ([]string, error) {
+	parts, err := parseMultipart(userData)
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []string
+	for idx, p := range parts {
+		mediaType, _, _ := mime.ParseMediaType(p.header.Get("Content-Type"))
+		if mediaType != "text/cloud-config" {
+			continue
+		}
+
+		body, err := p.decodedBody()
+		if err != nil {
+			return nil, fmt.Errorf("part %d: %w", idx, err)
+		}
+
+		cc, err := parseCloudConfig(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("part %d: %w", idx, err)
+		}
+
+		keys := slices.Sorted(maps.Keys(cc))
+		for _, k := range keys {
+			if !slices.Contains(cloudConfigKeys, k) {
+				warnings = append(warnings, fmt.Sprintf("part %d: unknown cloud-config key %q", idx, k))
+			}
+		}
+	}
+
+	return warnings, nil
+}
+
+// validateUserData checks user data of the spec, returns warnings
+func (g *InstanceGroup) validateUserData(userData string) ([]string, error) {
+	if strings.TrimSpace(userData) == "" {
+		return nil, nil
+	}
+
+	if g.UseIgnition {
+		return validateIgnition(userData)
+	}
+
+	return validateCloudConfig(userData)
+}
+
+// checkUserData validates user data and logs the warnings, in strict mode warnings are errors
+func (g *InstanceGroup) checkUserData(userData string) error {
+	warnings, err := g.validateUserData(userData)
+	for _, w := range warnings {
+		g.log.Warn("User data", "warning", w)
+	}
+	if err != nil {
+		return err
+	}
+
+	if g.Strict && len(warnings) > 0 {
+		return fmt.Errorf("user data has %d warnings: %s", len(warnings), strings.Join(warnings, "; "))
+	}
+
+	return nil
+}
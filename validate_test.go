@@ -0,0 +1,59 @@
+package fpoc
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckUserData(t *testing.T) {
+	testCases := []struct {
+		name        string
+		useIgnition bool
+		strict      bool
+		userData    string
+		warnings    int
+		isErr       bool
+	}{
+		{"ign-empty", true, true, "", 0, false},
+		{"ign-ok", true, true, `{"ignition":{"version":"3.3.0"}}`, 0, false},
+		{"ign-unused-key", true, false, `{"ignition":{"version":"3.3.0"},"sytemd":{}}`, 1, false},
+		{"ign-unused-key-strict", true, true, `{"ignition":{"version":"3.3.0"},"sytemd":{}}`, 1, true},
+		{"ign-invalid", true, false, `{"ignition":{"version":"3.3.0"},"storage":{"files":[{"path":"relative"}]}}`, 0, true},
+		{"ign-broken", true, false, `{"ignition":`, 0, true},
+		{"ign-cloud-config", true, false, "#cloud-config\n", 0, true},
+		{"cc-ok", false, true, "#cloud-config\nruncmd: [id]\nwrite_files: []\n", 0, false},
+		{"cc-unknown-key", false, false, "#cloud-config\nruncmd: [id]\nrun_cmd: [id]\n", 1, false},
+		{"cc-unknown-key-strict", false, true, "#cloud-config\nrun_cmd: [id]\n", 1, true},
+		{"cc-broken-yaml", false, false, "#cloud-config\nruncmd: [id\n", 0, true},
+		{"cc-duplicate-key", false, false, "#cloud-config\nruncmd: []\nruncmd: []\n", 0, true},
+		{"script", false, true, "#!/bin/sh\nrun_cmd: [\n", 0, false},
+		{"mime", false, false, "Content-Type: multipart/mixed; boundary=\"b\"\r\nMIME-Version: 1.0\r\n\r\n--b\r\nContent-Type: text/x-shellscript\r\n\r\n#!/bin/sh\r\n--b\r\nContent-Type: text/cloud-config\r\n\r\n#cloud-config\npackages: [git]\nmy_key: 1\n\r\n--b--\r\n", 1, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			g := &InstanceGroup{
+				UseIgnition: tc.useIgnition,
+				Strict:      tc.strict,
+				log:         hclog.NewNullLogger(),
+			}
+
+			warnings, err := g.validateUserData(tc.userData)
+			if err == nil {
+				assert.Len(warnings, tc.warnings, "%v", warnings)
+			}
+
+			err = g.checkUserData(tc.userData)
+			if tc.isErr {
+				assert.Error(err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package fpoc
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	ZoneRoundRobin     = "round-robin"
+	ZoneLeastPopulated = "least-populated"
+	ZoneWeighted       = "weighted"
+
+	// ZoneMetadataKey server metadata key of the requested availability zone
+	ZoneMetadataKey = "fleeting-availability-zone"
+)
+
+// ZoneConfig configures spreading of the instances across availability zones
+type ZoneConfig struct {
+	Zones        []string       `json:"zones"`        // availability zones used for the instances
+	Strategy     string         `json:"strategy"`     // optional: round-robin (default), least-populated or weighted
+	Weights      map[string]int `json:"weights"`      // optional: weights of the zones for weighted strategy, default 1
+	MaxFailures  int            `json:"max_failures"` // optional: exclude zone after that many scheduling failures within exclude_time, default 3
+	ExcludeTimeS string         `json:"exclude_time"` // optional: time for which failing zone is excluded, default 10m
+	ExcludeTime  time.Duration
+}
+
+func (zc *ZoneConfig) parse() error {
+	var err error
+
+	if slices.Contains(zc.Zones, "") {
+		return fmt.Errorf("zones must not have empty entries")
+	}
+
+	switch zc.Strategy {
+	case "":
+		zc.Strategy = ZoneRoundRobin
+	case ZoneRoundRobin, ZoneLeastPopulated, ZoneWeighted:
+	default:
+		return fmt.Errorf("unknown strategy: %s", zc.Strategy)
+	}
+
+	for zone, weight := range zc.Weights {
+		if !slices.Contains(zc.Zones, zone) {
+			return fmt.Errorf("weight of unknown zone: %s", zone)
+		}
+		if weight < 0 {
+			return fmt.Errorf("weight of zone %s must not be negative", zone)
+		}
+	}
+
+	if zc.MaxFailures <= 0 {
+		zc.MaxFailures = 3
+	}
+
+	zc.ExcludeTime = 10 * time.Minute
+	if zc.ExcludeTimeS != "" {
+		zc.ExcludeTime, err = time.ParseDuration(zc.ExcludeTimeS)
+		if err != nil {
+			return fmt.Errorf("failed to parse exclude_time: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (zc *ZoneConfig) weight(zone string) int {
+	if weight, ok := zc.Weights[zone]; ok {
+		return weight
+	}
+
+	return 1
+}
+
+// zoneOf returns the zone requested for the server, or the one it's in
+func zoneOf(srv *servers.Server) string {
+	if zone := srv.Metadata[ZoneMetadataKey]; zone != "" {
+		return zone
+	}
+
+	return srv.AvailabilityZone
+}
+
+// zoneBalancer selects availability zones for new instances
+type zoneBalancer struct {
+	cfg *ZoneConfig
+	log hclog.Logger
+
+	mu       sync.Mutex
+	next     int                    // round-robin position
+	current  map[string]int         // weighted: smooth weighted round-robin state
+	counts   map[string]int         // instances per zone, as of last observe and picks since
+	failures map[string][]time.Time // scheduling failures per zone within exclude_time
+	failed   map[string]struct{}    // servers already counted as failures
+	excluded map[string]time.Time   // zone -> end of exclusion
+}
+
+func newZoneBalancer(cfg *ZoneConfig, log hclog.Logger) *zoneBalancer {
+	return &zoneBalancer{
+		cfg:      cfg,
+		log:      log.Named("zones"),
+		current:  make(map[string]int),
+		counts:   make(map[string]int),
+		failures: make(map[string][]time.Time),
+		failed:   make(map[string]struct{}),
+		excluded: make(map[string]time.Time),
+	}
+}
+
+// eligible returns zones not excluded, all zones if every one is excluded. Caller must hold the lock.
+func (zb *zoneBalancer) eligible(now time.Time) []string {
+	ret := make([]string, 0, len(zb.cfg.Zones))
+	for _, zone := range zb.cfg.Zones {
+		until, ok := zb.excluded[zone]
+		if ok && now.Before(until) {
+			continue
+		} else if ok {
+			delete(zb.excluded, zone)
+			zb.log.Info("Availability zone exclusion expired", "zone", zone)
+		}
+
+		if zb.cfg.Strategy == ZoneWeighted && zb.cfg.weight(zone) == 0 {
+			continue
+		}
+
+		ret = append(ret, zone)
+	}
+
+	if len(ret) == 0 {
+		zb.log.Warn("All availability zones are excluded, using all of them")
+		return zb.cfg.Zones
+	}
+
+	return ret
+}
+
+// pick selects the zone for a new instance
+func (zb *zoneBalancer) pick() string {
+	zb.mu.Lock()
+	defer zb.mu.Unlock()
+
+	zones := zb.eligible(time.Now())
+
+	var zone string
+	switch zb.cfg.Strategy {
+	case ZoneLeastPopulated:
+		for _, z := range zones {
+			if zone == "" || zb.counts[z] < zb.counts[zone] {
+				zone = z
+			}
+		}
+
+	case ZoneWeighted:
+		total := 0
+		for _, z := range zones {
+			weight := max(zb.cfg.weight(z), 1)
+			zb.current[z] += weight
+			total += weight
+
+			if zone == "" || zb.current[z] > zb.current[zone] {
+				zone = z
+			}
+		}
+		zb.current[zone] -= total
+
+	default:
+		zone = zones[zb.next%len(zones)]
+		zb.next++
+	}
+
+	zb.counts[zone]++
+
+	return zone
+}
+
+// observe counts instances per zone and excludes zones failing to schedule them
+func (zb *zoneBalancer) observe(instances []servers.Server) {
+	zb.mu.Lock()
+	defer zb.mu.Unlock()
+
+	now := time.Now()
+	alive := make(map[string]struct{}, len(instances))
+	counts := make(map[string]int, len(zb.cfg.Zones))
+	for _, zone := range zb.cfg.Zones {
+		counts[zone] = 0
+	}
+
+	for _, srv := range instances {
+		alive[srv.ID] = struct{}{}
+		zone := zoneOf(&srv)
+		if zone == "" {
+			continue
+		}
+
+		if srv.Status != "ERROR" || !strings.Contains(srv.Fault.Message, noValidHostFault) {
+			counts[zone]++
+			continue
+		}
+
+		if _, ok := zb.failed[srv.ID]; ok {
+			continue
+		}
+		zb.failed[srv.ID] = struct{}{}
+		zb.recordFailure(zone, now)
+	}
+
+	for id := range zb.failed {
+		if _, ok := alive[id]; !ok {
+			delete(zb.failed, id)
+		}
+	}
+
+	if !maps.Equal(counts, zb.counts) {
+		zb.log.Info("Instances per availability zone", "distribution", counts)
+	}
+	zb.counts = counts
+}
+
+// recordFailure excludes the zone after max_failures within exclude_time. Caller must hold the lock.
+func (zb *zoneBalancer) recordFailure(zone string, now time.Time) {
+	if !slices.Contains(zb.cfg.Zones, zone) {
+		return
+	}
+
+	recent := slices.DeleteFunc(zb.failures[zone], func(t time.Time) bool {
+		return now.Sub(t) > zb.cfg.ExcludeTime
+	})
+	recent = append(recent, now)
+
+	if len(recent) < zb.cfg.MaxFailures {
+		zb.failures[zone] = recent
+		return
+	}
+
+	delete(zb.failures, zone)
+	zb.excluded[zone] = now.Add(zb.cfg.ExcludeTime)
+	zb.log.Warn("Availability zone excluded after scheduling failures", "zone", zone, "failures", len(recent), "until", zb.excluded[zone])
+}
+
+// distribution returns number of instances per zone
+func (zb *zoneBalancer) distribution() map[string]int {
+	zb.mu.Lock()
+	defer zb.mu.Unlock()
+
+	return maps.Clone(zb.counts)
+}
+
+// initZones creates zone balancers, availability_zones of the group apply to the primary backend only
+func (g *InstanceGroup) initZones() error {
+	for idx, b := range g.getBackends() {
+		cfg, specZone, option := &g.AvailabilityZones, g.ServerSpec.AvailabilityZone, "availability_zones"
+		if b.name != "" {
+			bc := &g.Backends[idx-1]
+			cfg, specZone, option = &bc.AvailabilityZones, bc.ServerSpec.AvailabilityZone, fmt.Sprintf("backends[%d].availability_zones", idx-1)
+		}
+
+		if len(cfg.Zones) == 0 {
+			continue
+		}
+		if specZone != "" {
+			return fmt.Errorf("only one of server_spec.availability_zone and %s may be set", option)
+		}
+
+		err := cfg.parse()
+		if err != nil {
+			return fmt.Errorf("%s: %w", option, err)
+		}
+
+		b.zones = newZoneBalancer(cfg, g.log.With("backend", b.name))
+	}
+
+	return nil
+}
+
+// severalZones tells if instances of some backend are spread across several zones
+func (g *InstanceGroup) severalZones() bool {
+	if len(g.AvailabilityZones.Zones) > 1 {
+		return true
+	}
+
+	for _, bc := range g.Backends {
+		if len(bc.AvailabilityZones.Zones) > 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// observeZones passes instances of each backend to its zone balancer
+func (g *InstanceGroup) observeZones(instances []servers.Server) {
+	for _, b := range g.getBackends() {
+		if b.zones == nil {
+			continue
+		}
+
+		owned := make([]servers.Server, 0, len(instances))
+		for _, srv := range instances {
+			if ob, _, err := g.route(srv.ID); err == nil && ob == b {
+				owned = append(owned, srv)
+			}
+		}
+
+		b.zones.observe(owned)
+	}
+}
+
+// logZones logs number of instances per zone of each backend
+func (g *InstanceGroup) logZones() {
+	for _, b := range g.getBackends() {
+		if b.zones != nil {
+			g.log.Info("Instances per availability zone", "backend", b.name, "distribution", b.zones.distribution())
+		}
+	}
+}
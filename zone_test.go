@@ -0,0 +1,160 @@
+package fpoc
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZoneConfig(t *testing.T) {
+	testCases := []struct {
+		name   string
+		cfg    ZoneConfig
+		errMsg string
+	}{
+		{"defaults", ZoneConfig{Zones: []string{"az1", "az2"}}, ""},
+		{"weighted", ZoneConfig{Zones: []string{"az1", "az2"}, Strategy: ZoneWeighted, Weights: map[string]int{"az1": 3}}, ""},
+		{"unknown strategy", ZoneConfig{Zones: []string{"az1"}, Strategy: "random"}, "unknown strategy: random"},
+		{"empty zone", ZoneConfig{Zones: []string{"az1", ""}}, "zones must not have empty entries"},
+		{"unknown weight", ZoneConfig{Zones: []string{"az1"}, Weights: map[string]int{"az3": 1}}, "weight of unknown zone: az3"},
+		{"bad exclude time", ZoneConfig{Zones: []string{"az1"}, ExcludeTimeS: "soon"}, "failed to parse exclude_time"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.cfg.parse()
+			if tc.errMsg != "" {
+				assert.ErrorContains(t, err, tc.errMsg)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.NotEmpty(t, tc.cfg.Strategy)
+			assert.Equal(t, 3, tc.cfg.MaxFailures)
+		})
+	}
+}
+
+func zoneServers(counts map[string]int) []servers.Server {
+	var ret []servers.Server
+	for zone, count := range counts {
+		for idx := range count {
+			ret = append(ret, servers.Server{
+				ID:       fmt.Sprintf("%s-%d", zone, idx),
+				Status:   "ACTIVE",
+				Metadata: map[string]string{ZoneMetadataKey: zone},
+			})
+		}
+	}
+
+	return ret
+}
+
+func TestZoneBalancerPick(t *testing.T) {
+	testCases := []struct {
+		name     string
+		cfg      ZoneConfig
+		existing map[string]int
+		expected []string
+	}{
+		{
+			name:     "round-robin",
+			cfg:      ZoneConfig{Zones: []string{"az1", "az2", "az3"}},
+			expected: []string{"az1", "az2", "az3", "az1"},
+		},
+		{
+			name:     "least-populated",
+			cfg:      ZoneConfig{Zones: []string{"az1", "az2", "az3"}, Strategy: ZoneLeastPopulated},
+			existing: map[string]int{"az1": 2, "az2": 0, "az3": 1},
+			expected: []string{"az2", "az2", "az3", "az1"},
+		},
+		{
+			name:     "weighted",
+			cfg:      ZoneConfig{Zones: []string{"az1", "az2"}, Strategy: ZoneWeighted, Weights: map[string]int{"az1": 2}},
+			expected: []string{"az1", "az2", "az1", "az1", "az2", "az1"},
+		},
+		{
+			name:     "zero weight",
+			cfg:      ZoneConfig{Zones: []string{"az1", "az2"}, Strategy: ZoneWeighted, Weights: map[string]int{"az1": 0}},
+			expected: []string{"az2", "az2"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.NoError(t, tc.cfg.parse())
+
+			zb := newZoneBalancer(&tc.cfg, hclog.NewNullLogger())
+			zb.observe(zoneServers(tc.existing))
+
+			var picked []string
+			for range tc.expected {
+				picked = append(picked, zb.pick())
+			}
+
+			assert.Equal(t, tc.expected, picked)
+		})
+	}
+}
+
+func TestZoneBalancerExclusion(t *testing.T) {
+	cfg := ZoneConfig{Zones: []string{"az1", "az2"}, MaxFailures: 2}
+	require.NoError(t, cfg.parse())
+
+	zb := newZoneBalancer(&cfg, hclog.NewNullLogger())
+
+	failed := func(id, zone string) servers.Server {
+		return servers.Server{
+			ID:       id,
+			Status:   "ERROR",
+			Fault:    servers.Fault{Code: 500, Message: "No valid host was found. "},
+			Metadata: map[string]string{ZoneMetadataKey: zone},
+		}
+	}
+
+	// the same failed server is counted once
+	instances := append(zoneServers(map[string]int{"az2": 1}), failed("f1", "az1"))
+	zb.observe(instances)
+	zb.observe(instances)
+	assert.Equal(t, map[string]int{"az1": 0, "az2": 1}, zb.distribution())
+	assert.ElementsMatch(t, []string{"az1", "az2"}, []string{zb.pick(), zb.pick()})
+
+	zb.observe(append(instances, failed("f2", "az1")))
+	assert.Equal(t, []string{"az2", "az2", "az2"}, []string{zb.pick(), zb.pick(), zb.pick()})
+
+	// all zones excluded: use all of them
+	zb.observe([]servers.Server{failed("f3", "az2"), failed("f4", "az2")})
+	assert.ElementsMatch(t, []string{"az1", "az2"}, []string{zb.pick(), zb.pick()})
+}
+
+func TestInitZones(t *testing.T) {
+	g := &InstanceGroup{
+		log:               hclog.NewNullLogger(),
+		AvailabilityZones: ZoneConfig{Zones: []string{"az1", "az2"}},
+		Backends: []BackendConfig{
+			{Name: "west", AvailabilityZones: ZoneConfig{Zones: []string{"west-a", "west-b"}}},
+			{Name: "east"},
+		},
+	}
+	g.backends = []*backend{{weight: 1}, {name: "west", weight: 1}, {name: "east", weight: 1}}
+
+	require.NoError(t, g.initZones())
+	assert.Equal(t, "az1", g.backends[0].zones.pick())
+	assert.Equal(t, "west-a", g.backends[1].zones.pick())
+	assert.Nil(t, g.backends[2].zones, "zones of the group are not sent to other backends")
+
+	g.observeZones([]servers.Server{
+		{ID: "p1", Status: "ACTIVE", Metadata: map[string]string{ZoneMetadataKey: "az2"}},
+		{ID: "west/w1", Status: "ACTIVE", Metadata: map[string]string{ZoneMetadataKey: "west-b"}},
+	})
+	assert.Equal(t, map[string]int{"az1": 0, "az2": 1}, g.backends[0].zones.distribution())
+	assert.Equal(t, map[string]int{"west-a": 0, "west-b": 1}, g.backends[1].zones.distribution())
+
+	g.Backends[1].ServerSpec.AvailabilityZone = "east-a"
+	g.Backends[1].AvailabilityZones.Zones = []string{"east-a", "east-b"}
+	assert.EqualError(t, g.initZones(), "only one of server_spec.availability_zone and backends[1].availability_zones may be set")
+}